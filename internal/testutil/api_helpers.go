@@ -7,9 +7,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/schaermu/changedetection.io-exporter/pkg/data"
@@ -17,13 +20,28 @@ import (
 
 type ApiTestServerOptions struct {
 	fmt.Stringer
-	PricesAsArray bool
-	SystemInfo    *data.SystemInfo
+	PricesAsArray  bool
+	SystemInfo     *data.SystemInfo
+	ResponseDelay  time.Duration
+	FailingWatches []string
+	Faults         []Fault
+	Tags           map[string]*data.Tag
+	History        map[string]map[int64]*data.PriceData
+}
+
+// Fault makes the test server respond with Status to the first Count requests of Path.
+type Fault struct {
+	// Path to inject the fault into, an empty path matches all requests.
+	Path       string
+	Status     int
+	RetryAfter string
+	Body       string
+	Count      int
 }
 type ApiTestServerOption func(*ApiTestServerOptions)
 
 func (o ApiTestServerOptions) String() string {
-	return fmt.Sprintf("ApiTestServerOptions{PricesAsArray: %t, SystemInfo: %v}", o.PricesAsArray, o.SystemInfo)
+	return fmt.Sprintf("ApiTestServerOptions{PricesAsArray: %t, SystemInfo: %v, ResponseDelay: %s, FailingWatches: %v, Faults: %v}", o.PricesAsArray, o.SystemInfo, o.ResponseDelay, o.FailingWatches, o.Faults)
 }
 
 func WithPricesAsArray() ApiTestServerOption {
@@ -38,10 +56,114 @@ func WithSystemInfo(info *data.SystemInfo) ApiTestServerOption {
 	}
 }
 
+// WithResponseDelay delays every response of the server by the given duration.
+func WithResponseDelay(delay time.Duration) ApiTestServerOption {
+	return func(o *ApiTestServerOptions) {
+		o.ResponseDelay = delay
+	}
+}
+
+// WithFailingWatches makes all detail endpoints of the given watches respond with a server error.
+func WithFailingWatches(uuids ...string) ApiTestServerOption {
+	return func(o *ApiTestServerOptions) {
+		o.FailingWatches = append(o.FailingWatches, uuids...)
+	}
+}
+
+// WithTags makes the test server return tags on the tags endpoint.
+func WithTags(tags ...*data.Tag) ApiTestServerOption {
+	return func(o *ApiTestServerOptions) {
+		for _, tag := range tags {
+			o.Tags[tag.Uuid] = tag
+		}
+	}
+}
+
+// WithHistory makes the test server return the given price snapshots, keyed by timestamp, as the
+// history of the watch with the given id. Snapshots added to the map later on are served as well.
+func WithHistory(uuid string, snapshots map[int64]*data.PriceData) ApiTestServerOption {
+	return func(o *ApiTestServerOptions) {
+		o.History[uuid] = snapshots
+	}
+}
+
+// WithFaults injects the given faults into the responses of the server.
+func WithFaults(faults ...Fault) ApiTestServerOption {
+	return func(o *ApiTestServerOptions) {
+		o.Faults = append(o.Faults, faults...)
+	}
+}
+
 type ApiTestServer struct {
 	Server  *httptest.Server
 	Options ApiTestServerOptions
 	watches map[string]*data.WatchItem
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	requests    map[string]int
+	faults      map[int]int
+}
+
+// RequestCount returns how many times the given path (i.e. /api/v1/watch) has been requested.
+func (s *ApiTestServer) RequestCount(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// TotalRequestCount returns the number of requests the server handled across all paths.
+func (s *ApiTestServer) TotalRequestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for _, count := range s.requests {
+		total += count
+	}
+	return total
+}
+
+// MaxConcurrentRequests returns the highest number of requests the server handled at the same time.
+func (s *ApiTestServer) MaxConcurrentRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxInFlight
+}
+
+// injectFault writes the next pending fault for path and reports whether it did so.
+func (s *ApiTestServer) injectFault(rw http.ResponseWriter, path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.Options.Faults {
+		if (f.Path == "" || f.Path == path) && s.faults[i] < f.Count {
+			s.faults[i]++
+			if f.RetryAfter != "" {
+				rw.Header().Set("Retry-After", f.RetryAfter)
+			}
+			rw.WriteHeader(f.Status)
+			if f.Body != "" {
+				_, _ = rw.Write([]byte(f.Body))
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ApiTestServer) trackRequest(path string) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[path]++
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.inFlight--
+	}
 }
 
 func (s *ApiTestServer) URL() string {
@@ -61,6 +183,11 @@ func NewWatchDb(numItems int) map[string]*data.WatchItem {
 	return ret
 }
 
+// NewTestTag creates a tag with a random id.
+func NewTestTag(title string) *data.Tag {
+	return &data.Tag{Uuid: uuid.New().String(), Title: title}
+}
+
 func NewTestItem(title string, price float64, currency string, checkCount int, fetchTime float64, alertCount int) (string, *data.WatchItem) {
 	return uuid.New().String(), &data.WatchItem{
 		Title:                  title,
@@ -69,6 +196,8 @@ func NewTestItem(title string, price float64, currency string, checkCount int, f
 		FetchTime:              fetchTime,
 		NotificationAlertCount: alertCount,
 		LastCheckStatus:        200,
+		Processor:              "text_json_diff",
+		FetchBackend:           "html_requests",
 		PriceData: &data.PriceData{
 			Price:        price,
 			Currency:     currency,
@@ -77,6 +206,20 @@ func NewTestItem(title string, price float64, currency string, checkCount int, f
 	}
 }
 
+// NewTestRestockItem creates a watch using the restock detection processor, which carries its price
+// and stock state in the watch details instead of an offer snapshot.
+func NewTestRestockItem(title string, price float64, currency string, inStock bool) (string, *data.WatchItem) {
+	uuid, watch := NewTestItem(title, price, currency, 20, 15, 10)
+	watch.Processor = data.ProcessorRestockDiff
+	watch.PriceData = nil
+	watch.Restock = &data.RestockData{
+		InStock:  &inStock,
+		Price:    &price,
+		Currency: currency,
+	}
+	return uuid, watch
+}
+
 func writeJson(rw http.ResponseWriter, v any) {
 	if res, err := json.Marshal(v); err == nil {
 		rw.Header().Set("Content-Type", "application/json")
@@ -95,54 +238,95 @@ func CreateTestApiServer(t *testing.T, watches map[string]*data.WatchItem, optio
 	opts := ApiTestServerOptions{
 		PricesAsArray: false,
 		SystemInfo:    &data.SystemInfo{Version: "1.0.0", Uptime: 100, WatchCount: len(watches), OverdueWatches: []string{}, QueueSize: 0},
+		Tags:          make(map[string]*data.Tag),
+		History:       make(map[string]map[int64]*data.PriceData),
 	}
 	for _, o := range options {
 		o(&opts)
 	}
 	t.Log("pulled in options", opts)
 
-	return &ApiTestServer{
-		watches: watches,
-		Server: httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-			if req.URL.Path == "/api/v1/watch" {
-				writeJson(rw, watches)
-			} else if req.URL.Path == "/api/v1/systeminfo" {
-				writeJson(rw, opts.SystemInfo)
-			} else if watchDetailPattern.MatchString(req.URL.Path) {
-				// get UUID from path
-				matches := watchDetailPattern.FindStringSubmatch(req.URL.Path)
-				uuidIdx := watchDetailPattern.SubexpIndex("UUID")
-				uuid := matches[uuidIdx]
-
-				// find watch
-				watch, ok := watches[uuid]
-				if !ok {
-					t.Logf("could not find watch with id %s", uuid)
-					rw.WriteHeader(http.StatusNotFound)
-				} else {
-					actionIndex := watchDetailPattern.SubexpIndex("ACTION")
-					if actionIndex > -1 {
-						switch matches[actionIndex] {
-						case "history/latest":
-							// return price data
-							if opts.PricesAsArray {
-								writeJson(rw, []data.PriceData{*watch.PriceData})
+	server := &ApiTestServer{
+		Options:  opts,
+		watches:  watches,
+		requests: make(map[string]int),
+		faults:   make(map[int]int),
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		defer server.trackRequest(req.URL.Path)()
+		if opts.ResponseDelay > 0 {
+			time.Sleep(opts.ResponseDelay)
+		}
+		if server.injectFault(rw, req.URL.Path) {
+			return
+		}
+
+		if req.URL.Path == "/api/v1/watch" {
+			writeJson(rw, watches)
+		} else if req.URL.Path == "/api/v1/systeminfo" {
+			writeJson(rw, opts.SystemInfo)
+		} else if req.URL.Path == "/api/v1/tags" {
+			writeJson(rw, opts.Tags)
+		} else if watchDetailPattern.MatchString(req.URL.Path) {
+			// get UUID from path
+			matches := watchDetailPattern.FindStringSubmatch(req.URL.Path)
+			uuidIdx := watchDetailPattern.SubexpIndex("UUID")
+			uuid := matches[uuidIdx]
+
+			// find watch
+			watch, ok := watches[uuid]
+			if !ok {
+				t.Logf("could not find watch with id %s", uuid)
+				rw.WriteHeader(http.StatusNotFound)
+			} else if slices.Contains(opts.FailingWatches, uuid) {
+				rw.WriteHeader(http.StatusInternalServerError)
+			} else {
+				actionIndex := watchDetailPattern.SubexpIndex("ACTION")
+				if actionIndex > -1 {
+					switch matches[actionIndex] {
+					case "history/latest":
+						// return price data
+						if watch.Processor == data.ProcessorRestockDiff {
+							// restock watches snapshot their stock state as text
+							rw.Header().Set("Content-Type", "text/plain")
+							if _, err := rw.Write([]byte("In stock")); err != nil {
+								rw.WriteHeader(http.StatusInternalServerError)
+							}
+						} else if opts.PricesAsArray {
+							writeJson(rw, []data.PriceData{*watch.PriceData})
+						} else {
+							writeJson(rw, watch.PriceData)
+						}
+					case "history":
+						// return timestamps of all snapshots
+						history := make(map[string]string)
+						for timestamp := range opts.History[uuid] {
+							history[strconv.FormatInt(timestamp, 10)] = fmt.Sprintf("/datastore/%s/%d.txt", uuid, timestamp)
+						}
+						writeJson(rw, history)
+					default:
+						if timestamp, ok := strings.CutPrefix(matches[actionIndex], "history/"); ok {
+							// return price data of a single snapshot
+							ts, _ := strconv.ParseInt(timestamp, 10, 64)
+							if snapshot, ok := opts.History[uuid][ts]; ok {
+								writeJson(rw, snapshot)
 							} else {
-								writeJson(rw, watch.PriceData)
+								rw.WriteHeader(http.StatusNotFound)
 							}
-						default:
+						} else {
 							// return details
 							writeJson(rw, watch)
 						}
-					} else {
-						// return details
-						writeJson(rw, watch)
 					}
+				} else {
+					// return details
+					writeJson(rw, watch)
 				}
-			} else {
-				t.Logf("could not map path %s", req.URL.Path)
-				rw.WriteHeader((http.StatusNotFound))
 			}
-		})),
-	}
+		} else {
+			t.Logf("could not map path %s", req.URL.Path)
+			rw.WriteHeader((http.StatusNotFound))
+		}
+	}))
+	return server
 }
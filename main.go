@@ -3,37 +3,112 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
 	"github.com/schaermu/changedetection.io-exporter/pkg/collectors"
+	"github.com/schaermu/changedetection.io-exporter/pkg/config"
+	"github.com/schaermu/changedetection.io-exporter/pkg/server"
+	"github.com/schaermu/changedetection.io-exporter/pkg/version"
 
 	promcollectors "github.com/prometheus/client_golang/prometheus/collectors"
 
 	log "github.com/sirupsen/logrus"
 )
 
+const program = "changedetectionio_exporter"
+
+// Flags default to their environment variable, so the precedence of any setting is:
+// command-line flag > environment variable > configuration file > default value.
 var (
-	port     = os.Getenv("PORT")
-	logLevel = os.Getenv("LOG_LEVEL")
-	apiUrl   = os.Getenv("CDIO_API_BASE_URL")
-	apiKey   = os.Getenv("CDIO_API_KEY")
+	listenAddress = flag.String("web.listen-address", defaultListenAddress(), "Address to listen on for the web interface and telemetry (env WEB_LISTEN_ADDRESS or PORT).")
+	webConfigFile = flag.String("web.config.file", os.Getenv("WEB_CONFIG_FILE"), "Path to a web configuration file enabling TLS and/or authentication (env WEB_CONFIG_FILE).")
+	readTimeout   = flag.Duration("web.read-timeout", envDurationOrDefault("WEB_READ_TIMEOUT", 10*time.Second), "Maximum duration for reading an entire request (env WEB_READ_TIMEOUT).")
+	writeTimeout  = flag.Duration("web.write-timeout", envDurationOrDefault("WEB_WRITE_TIMEOUT", time.Minute), "Maximum duration before timing out writes of a response, must exceed the duration of a scrape (env WEB_WRITE_TIMEOUT).")
+	gracePeriod   = flag.Duration("web.shutdown-grace-period", envDurationOrDefault("WEB_SHUTDOWN_GRACE_PERIOD", 30*time.Second), "Time to wait for requests in flight to finish on shutdown (env WEB_SHUTDOWN_GRACE_PERIOD).")
+	telemetryPath = flag.String("web.telemetry-path", envOrDefault("WEB_TELEMETRY_PATH", "/metrics"), "Path under which to expose metrics (env WEB_TELEMETRY_PATH).")
+	lifecycle     = flag.Bool("web.enable-lifecycle", envBoolOrDefault("WEB_ENABLE_LIFECYCLE", false), "Enable reloading the configuration via HTTP requests to /-/reload (env WEB_ENABLE_LIFECYCLE).")
+	cdioUrl       = flag.String("cdio.url", "", "Base url of the changedetection.io instance (env CDIO_API_BASE_URL).")
+	apiKeyFile    = flag.String("cdio.api-key-file", "", "Path to a file containing the changedetection.io API key (env CDIO_API_KEY_FILE).")
+	logLevel      = flag.String("log.level", envOrDefault("LOG_LEVEL", "info"), "Only log messages with the given severity or above, one of: debug, info, warn, error, fatal (env LOG_LEVEL).")
+	logFormat     = flag.String("log.format", envOrDefault("LOG_FORMAT", "logfmt"), "Output format of log messages, one of: logfmt, json (env LOG_FORMAT).")
+	configFile    = flag.String("config.file", os.Getenv("CONFIG_FILE"), "Path to the configuration file, settings are read from environment variables only if omitted (env CONFIG_FILE).")
+	configCheck   = flag.Bool("config.check", false, "Validate the configuration and exit.")
+	showVersion   = flag.Bool("version", false, "Print version information and exit.")
 )
 
-func init() {
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp:   true,
-		TimestampFormat: "2006-01-02 15:04:05.000000",
-	})
+func envOrDefault(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func envDurationOrDefault(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s must be a duration (i.e. 30s), got %q\n", name, value)
+		os.Exit(1)
+	}
+	return d
+}
+
+func envBoolOrDefault(name string, defaultValue bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s must be a boolean, got %q\n", name, value)
+		os.Exit(1)
+	}
+	return b
+}
+
+func defaultListenAddress() string {
+	if port := os.Getenv("PORT"); port != "" {
+		// kept for backwards compatibility
+		return ":" + port
+	}
+	return envOrDefault("WEB_LISTEN_ADDRESS", ":9123")
+}
 
-	switch logLevel {
+func setupLogging(level string, format string) error {
+	switch format {
+	case "logfmt":
+		log.SetFormatter(&log.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: "2006-01-02 15:04:05.000000",
+		})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	switch level {
 	case "debug":
 		log.SetLevel(log.DebugLevel)
+	case "info":
+		log.SetLevel(log.InfoLevel)
 	case "warn":
 		log.SetLevel(log.WarnLevel)
 	case "error":
@@ -41,39 +116,103 @@ func init() {
 	case "fatal":
 		log.SetLevel(log.FatalLevel)
 	default:
-		log.SetLevel(log.InfoLevel)
+		return fmt.Errorf("unknown log level %q", level)
 	}
+	return nil
+}
+
+func loadConfig() (*config.Config, error) {
+	return config.Load(*configFile, config.Overrides{
+		Url:        *cdioUrl,
+		ApiKeyFile: *apiKeyFile,
+	})
 }
 
 func main() {
-	if port == "" {
-		port = "9123"
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(version.Print(program))
+		os.Exit(0)
 	}
-	if apiUrl == "" || apiKey == "" {
-		log.Fatal("CDIO_API_BASE_URL and CDIO_API_KEY environment variables must be set")
+
+	if err := setupLogging(*logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	client := cdio.NewApiClient(apiUrl, apiKey)
-	registry := prometheus.NewPedanticRegistry()
+	if *configCheck {
+		_, err := loadConfig()
+		if err := errors.Join(err, server.ValidateWebConfig(*webConfigFile)); err != nil {
+			fmt.Fprintf(os.Stderr, "configuration is invalid:\n%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("configuration is valid")
+		os.Exit(0)
+	}
+
+	if !strings.HasPrefix(*telemetryPath, "/") {
+		log.Fatalf("invalid telemetry path %q, must start with /", *telemetryPath)
+	}
 
-	// register default collectors
+	// register default collectors, they live as long as the process
+	clientMetrics := cdio.NewClientMetrics()
+	registry := prometheus.NewPedanticRegistry()
 	registry.MustRegister(
 		promcollectors.NewProcessCollector(promcollectors.ProcessCollectorOpts{}),
 		promcollectors.NewGoCollector(),
+		clientMetrics,
+		collectors.NewBuildInfoCollector(),
 	)
 
-	// register changedetection.io collectors
-	registry.MustRegister(
-		collectors.NewSystemCollector(client),
-		collectors.NewWatchCollector(client),
-		collectors.NewPriceCollector(client),
-	)
+	// build changedetection.io collectors from the configuration
+	reloader, err := server.NewReloader(loadConfig, registry, clientMetrics)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	// reload configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloader.Reload(); err != nil {
+				log.Error(err)
+			}
+		}
+	}()
 
 	// register prometheus handler
-	http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{
-		ErrorLog: log.StandardLogger(),
-	}))
-	log.Info(fmt.Sprintf("Beginning to serve on port %s", port))
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), nil))
+	http.Handle(*telemetryPath, reloader.MetricsHandler())
+	http.Handle("/probe", reloader.ProbeHandler())
+	if *lifecycle {
+		http.Handle("/-/reload", reloader.ReloadHandler())
+	}
+	http.Handle("/-/healthy", server.HealthyHandler())
+	http.Handle("/-/ready", reloader.ReadyHandler())
+	if *telemetryPath != "/" {
+		http.Handle("/", reloader.LandingPageHandler(*telemetryPath))
+	}
+	log.Infof("Starting %s %s (revision: %s)", program, version.Version, version.Revision)
+	if err := server.ValidateWebConfig(*webConfigFile); err != nil {
+		log.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", *listenAddress)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// serve until SIGTERM or SIGINT, then let requests in flight finish before stopping the pollers
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	srv := &http.Server{
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+	}
+	serveErr := server.ServeGracefully(ctx, listener, srv, *webConfigFile, *gracePeriod)
+	reloader.Stop()
+	if serveErr != nil {
+		log.Fatal(serveErr)
+	}
+	log.Info("shutdown complete")
 }
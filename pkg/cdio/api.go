@@ -3,41 +3,55 @@
 package cdio
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/schaermu/changedetection.io-exporter/pkg/data"
 	log "github.com/sirupsen/logrus"
 )
 
+// DefaultTimeout limits the duration of a single request to the changedetection.io API.
+const DefaultTimeout = 10 * time.Second
+
 type ApiClient struct {
 	Client  *http.Client
+	Retry   RetryPolicy
+	Metrics *ClientMetrics
 	baseUrl string
 	key     string
 }
 
 func NewApiClient(baseUrl string, key string) *ApiClient {
 	return &ApiClient{
-		Client:  &http.Client{},
+		Client:  &http.Client{Timeout: DefaultTimeout},
+		Retry:   DefaultRetryPolicy,
 		baseUrl: fmt.Sprintf("%s/api/v1", baseUrl),
 		key:     key,
 	}
 }
 
 func NewTestApiClient(url string) *ApiClient {
-	return NewApiClient(url, "foo-bar-key")
+	client := NewApiClient(url, "foo-bar-key")
+	client.Retry.InitialBackoff = time.Millisecond
+	client.Retry.MaxBackoff = 10 * time.Millisecond
+	return client
 }
 
 func (client *ApiClient) SetBaseUrl(baseUrl string) {
 	client.baseUrl = fmt.Sprintf("%s/api/v1", baseUrl)
 }
 
-func (client *ApiClient) getRequest(method string, url string, body io.Reader) (*http.Request, error) {
+func (client *ApiClient) getRequest(ctx context.Context, method string, url string, body io.Reader) (*http.Request, error) {
 	targetUrl := fmt.Sprintf("%s/%s", client.baseUrl, url)
 	log.Debugf("curl \"%s\" -H\"x-api-key:%s\"", targetUrl, client.key)
-	req, err := http.NewRequest(method, targetUrl, body)
+	req, err := http.NewRequestWithContext(ctx, method, targetUrl, body)
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -46,110 +60,154 @@ func (client *ApiClient) getRequest(method string, url string, body io.Reader) (
 	return req, nil
 }
 
-func (client *ApiClient) GetWatches() (map[string]*data.WatchItem, error) {
-	req, err := client.getRequest("GET", "watch", nil)
+// get requests url and returns the response body if the API responded with a 2xx status code.
+// The endpoint is the route template of url (i.e. watch/{uuid}) used to label metrics.
+func (client *ApiClient) get(ctx context.Context, endpoint string, url string) ([]byte, error) {
+	req, err := client.getRequest(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := client.Client.Do(req)
+	res, err := client.do(endpoint, req)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 
-	watches := make(map[string]*data.WatchItem)
-	err = json.NewDecoder(res.Body).Decode(&watches)
-	if err != nil {
-		return nil, err
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, newStatusError(url, res.StatusCode)
 	}
-	return watches, nil
+	return io.ReadAll(res.Body)
 }
 
-func (client *ApiClient) GetWatchData(id string) (*data.WatchItem, error) {
-	req, err := client.getRequest("GET", fmt.Sprintf("watch/%s", id), nil)
+// getJson requests url and decodes the response body into v.
+func (client *ApiClient) getJson(ctx context.Context, endpoint string, url string, v any) error {
+	body, err := client.get(ctx, endpoint, url)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return newDecodeError(url, body, err)
+	}
+	return nil
+}
 
-	res, err := client.Client.Do(req)
-	if err != nil {
+func (client *ApiClient) GetWatches() (map[string]*data.WatchItem, error) {
+	return client.GetWatchesContext(context.Background())
+}
+
+func (client *ApiClient) GetWatchesContext(ctx context.Context) (map[string]*data.WatchItem, error) {
+	watches := make(map[string]*data.WatchItem)
+	if err := client.getJson(ctx, "watch", "watch", &watches); err != nil {
 		return nil, err
 	}
+	return watches, nil
+}
 
-	switch res.StatusCode {
-	case 404:
-		// watch not found, was probably removed
-		return nil, fmt.Errorf("watch %s not found", id)
-	}
-	defer res.Body.Close()
+func (client *ApiClient) GetWatchData(id string) (*data.WatchItem, error) {
+	return client.GetWatchDataContext(context.Background(), id)
+}
 
+func (client *ApiClient) GetWatchDataContext(ctx context.Context, id string) (*data.WatchItem, error) {
 	var watchItem = data.WatchItem{}
-	err = json.NewDecoder(res.Body).Decode(&watchItem)
-	if err != nil {
+	if err := client.getJson(ctx, "watch/{uuid}", fmt.Sprintf("watch/%s", id), &watchItem); err != nil {
 		return nil, err
 	}
 	return &watchItem, nil
 }
 
 func (client *ApiClient) GetLatestPriceSnapshot(id string) (*data.PriceData, error) {
-	req, err := client.getRequest("GET", fmt.Sprintf("watch/%s/history/latest", id), nil)
-	if err != nil {
-		return nil, err
-	}
+	return client.GetLatestPriceSnapshotContext(context.Background(), id)
+}
+
+func (client *ApiClient) GetLatestPriceSnapshotContext(ctx context.Context, id string) (*data.PriceData, error) {
+	return client.getPriceSnapshot(ctx, "watch/{uuid}/history/latest", fmt.Sprintf("watch/%s/history/latest", id))
+}
+
+// GetHistory returns the timestamps of all snapshots of a watch, oldest first.
+func (client *ApiClient) GetHistory(id string) ([]int64, error) {
+	return client.GetHistoryContext(context.Background(), id)
+}
 
-	res, err := client.Client.Do(req)
+func (client *ApiClient) GetHistoryContext(ctx context.Context, id string) ([]int64, error) {
+	url := fmt.Sprintf("watch/%s/history", id)
+	body, err := client.get(ctx, "watch/{uuid}/history", url)
 	if err != nil {
 		return nil, err
 	}
 
-	if res.StatusCode == 404 {
-		// watch not found, was probably removed
-		return nil, fmt.Errorf("watch %s not found", id)
+	// the history maps the timestamp of each snapshot to its path on the server
+	history := make(map[string]string)
+	if err := json.Unmarshal(body, &history); err != nil {
+		return nil, newDecodeError(url, body, err)
 	}
+	timestamps := make([]int64, 0, len(history))
+	for key := range history {
+		timestamp, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return nil, newDecodeError(url, body, fmt.Errorf("invalid snapshot timestamp %q", key))
+		}
+		timestamps = append(timestamps, timestamp)
+	}
+	slices.Sort(timestamps)
+	return timestamps, nil
+}
 
-	defer res.Body.Close()
+// GetPriceSnapshot returns the price data of the snapshot of a watch taken at timestamp.
+func (client *ApiClient) GetPriceSnapshot(id string, timestamp int64) (*data.PriceData, error) {
+	return client.GetPriceSnapshotContext(context.Background(), id, timestamp)
+}
+
+func (client *ApiClient) GetPriceSnapshotContext(ctx context.Context, id string, timestamp int64) (*data.PriceData, error) {
+	return client.getPriceSnapshot(ctx, "watch/{uuid}/history/{timestamp}", fmt.Sprintf("watch/%s/history/%d", id, timestamp))
+}
 
-	bodyText, err := io.ReadAll(res.Body)
+func (client *ApiClient) getPriceSnapshot(ctx context.Context, endpoint string, url string) (*data.PriceData, error) {
+	body, err := client.get(ctx, endpoint, url)
 	if err != nil {
 		return nil, err
 	}
 
-	var priceData = data.PriceData{}
-	err = json.Unmarshal(bodyText, &priceData)
-	if err != nil {
-		// check if the error is due to the response being an array
-		if err.Error() == "json: cannot unmarshal array into Go value of type data.PriceData" {
-			var priceDataArray []data.PriceData
-			err = json.Unmarshal(bodyText, &priceDataArray)
-			if err != nil {
-				log.Error(err)
-				return nil, err
-			}
-			return &priceDataArray[0], nil
+	// depending on the page, the snapshot contains either a single offer or a list of them
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var priceDataArray []data.PriceData
+		if err := json.Unmarshal(trimmed, &priceDataArray); err != nil {
+			return nil, newDecodeError(url, body, err)
 		}
-		log.Error(err)
-		return nil, err
+		if len(priceDataArray) == 0 {
+			return nil, newDecodeError(url, body, fmt.Errorf("snapshot contains no price data"))
+		}
+		return &priceDataArray[0], nil
+	}
+
+	var priceData = data.PriceData{}
+	if err := json.Unmarshal(trimmed, &priceData); err != nil {
+		return nil, newDecodeError(url, body, err)
 	}
 	return &priceData, nil
 }
 
 func (client *ApiClient) GetSystemInfo() (*data.SystemInfo, error) {
-	req, err := client.getRequest("GET", "systeminfo", nil)
-	if err != nil {
-		return nil, err
-	}
+	return client.GetSystemInfoContext(context.Background())
+}
 
-	res, err := client.Client.Do(req)
-	if err != nil {
+func (client *ApiClient) GetSystemInfoContext(ctx context.Context) (*data.SystemInfo, error) {
+	var systemInfo = data.SystemInfo{}
+	if err := client.getJson(ctx, "systeminfo", "systeminfo", &systemInfo); err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
+	return &systemInfo, nil
+}
 
-	var systemInfo = data.SystemInfo{}
-	err = json.NewDecoder(res.Body).Decode(&systemInfo)
-	if err != nil {
+func (client *ApiClient) GetTags() (map[string]*data.Tag, error) {
+	return client.GetTagsContext(context.Background())
+}
+
+func (client *ApiClient) GetTagsContext(ctx context.Context) (map[string]*data.Tag, error) {
+	tags := make(map[string]*data.Tag)
+	if err := client.getJson(ctx, "tags", "tags", &tags); err != nil {
 		return nil, err
 	}
-	return &systemInfo, nil
+	return tags, nil
 }
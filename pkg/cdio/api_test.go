@@ -3,8 +3,12 @@
 package cdio
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
 	"github.com/schaermu/changedetection.io-exporter/pkg/data"
@@ -18,7 +22,7 @@ func TestGetRequestApiKey(t *testing.T) {
 	apiKey := "manual-api-key"
 
 	api := NewApiClient(server.URL(), apiKey)
-	request, err := api.getRequest("GET", "/watch", nil)
+	request, err := api.getRequest(context.Background(), "GET", "/watch", nil)
 	testutil.Ok(t, err)
 	testutil.Equals(t, apiKey, request.Header.Get("x-api-key"))
 }
@@ -37,6 +41,25 @@ func TestGetWatches(t *testing.T) {
 	testutil.Equals(t, "Test Me", watches[uuid].Title)
 }
 
+func TestGetWatchesContext_Cancelled(t *testing.T) {
+	watchDb := testutil.NewWatchDb(1)
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithResponseDelay(time.Second))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	api := NewTestApiClient(server.URL())
+	watches, err := api.GetWatchesContext(ctx)
+	testutil.Assert(t, errors.Is(err, context.DeadlineExceeded), "expected deadline exceeded, got %v", err)
+	testutil.Equals(t, 0, len(watches))
+}
+
+func TestNewApiClient_DefaultTimeout(t *testing.T) {
+	api := NewApiClient("http://localhost:8080", "foo-bar-key")
+	testutil.Equals(t, DefaultTimeout, api.Client.Timeout)
+}
+
 func TestGetWatchData(t *testing.T) {
 	watchDb := testutil.NewWatchDb(1)
 	uuid, watch := testutil.NewTestItem("Test Me", 100, "USD", 20, 15, 10)
@@ -63,7 +86,7 @@ func TestGetWatchData_NotFound(t *testing.T) {
 	api := NewTestApiClient(server.URL())
 	watchItem, err := api.GetWatchData(nonExistingId)
 
-	testutil.Equals(t, fmt.Errorf("watch %s not found", nonExistingId), err)
+	testutil.Assert(t, errors.Is(err, ErrNotFound), "expected not found error, got %v", err)
 	testutil.Equals(t, (*data.WatchItem)(nil), watchItem)
 }
 
@@ -97,7 +120,7 @@ func TestGetLastestPriceSnapshot_NotFound(t *testing.T) {
 
 	api := NewTestApiClient(server.URL())
 	priceData, err := api.GetLatestPriceSnapshot(nonExistingId)
-	testutil.Equals(t, fmt.Errorf("watch %s not found", nonExistingId), err)
+	testutil.Assert(t, errors.Is(err, ErrNotFound), "expected not found error, got %v", err)
 	testutil.Equals(t, (*data.PriceData)(nil), priceData)
 }
 
@@ -116,6 +139,52 @@ func TestGetLatestPriceSnapshot_ArrayPriceData(t *testing.T) {
 	testutil.Equals(t, "USD", priceData.Currency)
 }
 
+func TestGetHistory(t *testing.T) {
+	watchDb := testutil.NewWatchDb(0)
+	uuid, watchItem := testutil.NewTestItem("Test Me", 100, "USD", 20, 15, 10)
+	watchDb[uuid] = watchItem
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithHistory(uuid, map[int64]*data.PriceData{
+		1714600000: {Price: 90, Currency: "USD"},
+		1714500000: {Price: 100, Currency: "USD"},
+		1714700000: {Price: 95, Currency: "USD"},
+	}))
+	defer server.Close()
+
+	api := NewTestApiClient(server.URL())
+	timestamps, err := api.GetHistory(uuid)
+
+	testutil.Ok(t, err)
+	testutil.Equals(t, []int64{1714500000, 1714600000, 1714700000}, timestamps)
+}
+
+func TestGetHistory_NotFound(t *testing.T) {
+	server := testutil.CreateTestApiServer(t, testutil.NewWatchDb(1))
+	defer server.Close()
+
+	api := NewTestApiClient(server.URL())
+	_, err := api.GetHistory("i-surely-do-not-exist")
+	testutil.Assert(t, errors.Is(err, ErrNotFound), "expected not found error, got %v", err)
+}
+
+func TestGetPriceSnapshot(t *testing.T) {
+	watchDb := testutil.NewWatchDb(0)
+	uuid, watchItem := testutil.NewTestItem("Test Me", 100, "USD", 20, 15, 10)
+	watchDb[uuid] = watchItem
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithHistory(uuid, map[int64]*data.PriceData{
+		1714500000: {Price: 90, Currency: "USD"},
+	}))
+	defer server.Close()
+
+	api := NewTestApiClient(server.URL())
+	priceData, err := api.GetPriceSnapshot(uuid, 1714500000)
+
+	testutil.Ok(t, err)
+	testutil.Equals(t, float64(90), priceData.Price)
+
+	_, err = api.GetPriceSnapshot(uuid, 1714600000)
+	testutil.Assert(t, errors.Is(err, ErrNotFound), "expected not found error, got %v", err)
+}
+
 func TestGetSystemInfo(t *testing.T) {
 	watchDb := testutil.NewWatchDb(1)
 	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithSystemInfo(&data.SystemInfo{
@@ -129,3 +198,37 @@ func TestGetSystemInfo(t *testing.T) {
 	testutil.Ok(t, err)
 	testutil.Equals(t, "1.0.0", info.Version)
 }
+
+func TestGetTags(t *testing.T) {
+	watchDb := testutil.NewWatchDb(1)
+	tag := testutil.NewTestTag("vendor: acme")
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithTags(tag))
+	defer server.Close()
+
+	api := NewTestApiClient(server.URL())
+	tags, err := api.GetTags()
+
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(tags))
+	testutil.Equals(t, "vendor: acme", tags[tag.Uuid].Title)
+}
+
+func TestGetLatestPriceSnapshot_EmptyArray(t *testing.T) {
+	watchDb := testutil.NewWatchDb(0)
+	uuid, watchItem := testutil.NewTestItem("Test Me", 100, "USD", 20, 15, 10)
+	watchDb[uuid] = watchItem
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithFaults(testutil.Fault{
+		Path:   fmt.Sprintf("/api/v1/watch/%s/history/latest", uuid),
+		Status: http.StatusOK,
+		Body:   "[]",
+		Count:  1,
+	}))
+	defer server.Close()
+
+	api := NewTestApiClient(server.URL())
+	priceData, err := api.GetLatestPriceSnapshot(uuid)
+
+	var decodeErr *DecodeError
+	testutil.Assert(t, errors.As(err, &decodeErr), "expected decode error, got %v", err)
+	testutil.Equals(t, (*data.PriceData)(nil), priceData)
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package cdio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrUnauthorized     = errors.New("unauthorized, check the api key")
+	ErrForbidden        = errors.New("forbidden")
+	ErrRateLimited      = errors.New("rate limited")
+	ErrServerError      = errors.New("server error")
+	ErrUnexpectedStatus = errors.New("unexpected status")
+)
+
+// maxBodyExcerpt limits the number of bytes of a response body included in a DecodeError.
+const maxBodyExcerpt = 256
+
+// StatusError is returned whenever the API responds with a non-2xx status code. It wraps one of
+// the sentinel errors above, so callers can use errors.Is to branch on the kind of failure.
+type StatusError struct {
+	Path       string
+	StatusCode int
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %v (status %d)", e.Path, e.Err, e.StatusCode)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+func newStatusError(path string, statusCode int) *StatusError {
+	var err error
+	switch {
+	case statusCode == http.StatusUnauthorized:
+		err = ErrUnauthorized
+	case statusCode == http.StatusForbidden:
+		err = ErrForbidden
+	case statusCode == http.StatusNotFound:
+		err = ErrNotFound
+	case statusCode == http.StatusTooManyRequests:
+		err = ErrRateLimited
+	case statusCode >= 500:
+		err = ErrServerError
+	default:
+		err = ErrUnexpectedStatus
+	}
+	return &StatusError{Path: path, StatusCode: statusCode, Err: err}
+}
+
+// DecodeError is returned when a response body can not be decoded. It carries an excerpt of the
+// body to help debugging unexpected payloads (i.e. an HTML error page of a reverse proxy).
+type DecodeError struct {
+	Path string
+	Body string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s: could not decode response: %v (body: %q)", e.Path, e.Err, e.Body)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func newDecodeError(path string, body []byte, err error) *DecodeError {
+	excerpt := strings.TrimSpace(string(body))
+	if len(excerpt) > maxBodyExcerpt {
+		excerpt = excerpt[:maxBodyExcerpt]
+		// do not cut a multi-byte character in half
+		for !utf8.ValidString(excerpt) {
+			excerpt = excerpt[:len(excerpt)-1]
+		}
+		excerpt += "..."
+	}
+	return &DecodeError{Path: path, Body: excerpt, Err: err}
+}
+
+// ErrorReason returns a short, stable description of err suitable for logs and labels.
+func ErrorReason(err error) string {
+	var decodeErr *DecodeError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, ErrForbidden):
+		return "forbidden"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrServerError):
+		return "server_error"
+	case errors.Is(err, ErrUnexpectedStatus):
+		return "unexpected_status"
+	case errors.As(err, &decodeErr):
+		return "decode_error"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	default:
+		return "network_error"
+	}
+}
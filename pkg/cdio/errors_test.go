@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package cdio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+)
+
+func TestStatusErrors(t *testing.T) {
+	cases := []struct {
+		status int
+		err    error
+		reason string
+	}{
+		{http.StatusUnauthorized, ErrUnauthorized, "unauthorized"},
+		{http.StatusForbidden, ErrForbidden, "forbidden"},
+		{http.StatusNotFound, ErrNotFound, "not_found"},
+		{http.StatusTooManyRequests, ErrRateLimited, "rate_limited"},
+		{http.StatusInternalServerError, ErrServerError, "server_error"},
+		{http.StatusServiceUnavailable, ErrServerError, "server_error"},
+		{http.StatusTeapot, ErrUnexpectedStatus, "unexpected_status"},
+	}
+
+	for _, c := range cases {
+		server := testutil.CreateTestApiServer(t, testutil.NewWatchDb(1), testutil.WithFaults(testutil.Fault{
+			Status: c.status,
+			Count:  100,
+		}))
+
+		api := NewTestApiClient(server.URL())
+		_, watchesErr := api.GetWatches()
+		_, systemErr := api.GetSystemInfo()
+		server.Close()
+
+		for _, err := range []error{watchesErr, systemErr} {
+			testutil.Assert(t, errors.Is(err, c.err), "expected %v for status %d, got %v", c.err, c.status, err)
+			var statusErr *StatusError
+			testutil.Assert(t, errors.As(err, &statusErr), "expected status error, got %T", err)
+			testutil.Equals(t, c.status, statusErr.StatusCode)
+			testutil.Equals(t, c.reason, ErrorReason(err))
+		}
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	server := testutil.CreateTestApiServer(t, testutil.NewWatchDb(1), testutil.WithFaults(testutil.Fault{
+		Path:   "/api/v1/watch",
+		Status: http.StatusOK,
+		Body:   "<html><body>Bad Gateway</body></html>",
+		Count:  1,
+	}))
+	defer server.Close()
+
+	api := NewTestApiClient(server.URL())
+	_, err := api.GetWatches()
+
+	var decodeErr *DecodeError
+	testutil.Assert(t, errors.As(err, &decodeErr), "expected decode error, got %v", err)
+	testutil.Equals(t, "<html><body>Bad Gateway</body></html>", decodeErr.Body)
+	testutil.Equals(t, "decode_error", ErrorReason(err))
+}
+
+func TestDecodeError_TruncatesBody(t *testing.T) {
+	err := newDecodeError("watch", []byte(strings.Repeat("ä", maxBodyExcerpt)), errors.New("boom"))
+
+	testutil.Assert(t, len(err.Body) <= maxBodyExcerpt+3, "expected body to be truncated, got %d bytes", len(err.Body))
+	testutil.Assert(t, strings.HasSuffix(err.Body, "..."), "expected truncated body to end with ellipsis")
+	testutil.Assert(t, utf8.ValidString(err.Body), "expected no broken characters")
+}
+
+func TestErrorReason(t *testing.T) {
+	testutil.Equals(t, "", ErrorReason(nil))
+	testutil.Equals(t, "timeout", ErrorReason(context.DeadlineExceeded))
+	testutil.Equals(t, "cancelled", ErrorReason(context.Canceled))
+	testutil.Equals(t, "network_error", ErrorReason(errors.New("connection refused")))
+}
@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package cdio
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ClientMetrics instruments all requests an ApiClient sends to changedetection.io. It implements
+// prometheus.Collector and can be shared between multiple clients.
+type ClientMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+}
+
+func NewClientMetrics() *ClientMetrics {
+	return &ClientMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "changedetectionio",
+			Subsystem: "exporter",
+			Name:      "api_requests_total",
+			Help:      "Number of requests sent to the changedetection.io API",
+		}, []string{"endpoint", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "changedetectionio",
+			Subsystem: "exporter",
+			Name:      "api_request_duration_seconds",
+			Help:      "Duration of requests sent to the changedetection.io API",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"endpoint"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "changedetectionio",
+			Subsystem: "exporter",
+			Name:      "api_requests_in_flight",
+			Help:      "Number of requests to the changedetection.io API currently in flight",
+		}),
+	}
+}
+
+func (m *ClientMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.duration.Describe(ch)
+	m.inFlight.Describe(ch)
+}
+
+func (m *ClientMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.duration.Collect(ch)
+	m.inFlight.Collect(ch)
+}
+
+// start records the beginning of a request and returns a function recording its outcome. A status
+// code of 0 is recorded as code "error", meaning no response has been received.
+func (m *ClientMetrics) start(endpoint string) func(statusCode int) {
+	if m == nil {
+		return func(int) {}
+	}
+
+	start := time.Now()
+	m.inFlight.Inc()
+	return func(statusCode int) {
+		m.inFlight.Dec()
+		m.duration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+
+		code := "error"
+		if statusCode > 0 {
+			code = strconv.Itoa(statusCode)
+		}
+		m.requests.WithLabelValues(endpoint, code).Inc()
+	}
+}
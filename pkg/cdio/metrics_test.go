@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package cdio
+
+import (
+	"net/http"
+	"testing"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+)
+
+func TestClientMetrics(t *testing.T) {
+	watchDb := testutil.NewWatchDb(1)
+	uuid, watch := testutil.NewTestItem("Test Me", 100, "USD", 20, 15, 10)
+	watchDb[uuid] = watch
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithFaults(testutil.Fault{
+		Path:   "/api/v1/systeminfo",
+		Status: http.StatusServiceUnavailable,
+		Count:  1,
+	}))
+	defer server.Close()
+
+	metrics := NewClientMetrics()
+	api := NewTestApiClient(server.URL())
+	api.Metrics = metrics
+
+	_, err := api.GetWatches()
+	testutil.Ok(t, err)
+	_, err = api.GetWatchData(uuid)
+	testutil.Ok(t, err)
+	_, err = api.GetLatestPriceSnapshot(uuid)
+	testutil.Ok(t, err)
+	_, err = api.GetSystemInfo()
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, float64(1), promtestutil.ToFloat64(metrics.requests.WithLabelValues("watch", "200")))
+	testutil.Equals(t, float64(1), promtestutil.ToFloat64(metrics.requests.WithLabelValues("watch/{uuid}", "200")))
+	testutil.Equals(t, float64(1), promtestutil.ToFloat64(metrics.requests.WithLabelValues("watch/{uuid}/history/latest", "200")))
+	testutil.Equals(t, float64(1), promtestutil.ToFloat64(metrics.requests.WithLabelValues("systeminfo", "503")))
+	testutil.Equals(t, float64(1), promtestutil.ToFloat64(metrics.requests.WithLabelValues("systeminfo", "200")))
+	testutil.Equals(t, float64(0), promtestutil.ToFloat64(metrics.inFlight))
+	testutil.Equals(t, 4, promtestutil.CollectAndCount(metrics.duration))
+}
+
+func TestClientMetrics_NetworkError(t *testing.T) {
+	server := testutil.CreateTestApiServer(t, testutil.NewWatchDb(1))
+	server.Close()
+
+	metrics := NewClientMetrics()
+	api := NewTestApiClient(server.URL())
+	api.Retry.MaxAttempts = 1
+	api.Metrics = metrics
+
+	_, err := api.GetWatches()
+	testutil.Assert(t, err != nil, "expected error for closed server")
+	testutil.Equals(t, float64(1), promtestutil.ToFloat64(metrics.requests.WithLabelValues("watch", "error")))
+}
+
+func TestClientMetrics_Collector(t *testing.T) {
+	metrics := NewClientMetrics()
+	metrics.start("watch")(200)
+
+	testutil.Equals(t, 3, promtestutil.CollectAndCount(metrics,
+		"changedetectionio_exporter_api_requests_total",
+		"changedetectionio_exporter_api_request_duration_seconds",
+		"changedetectionio_exporter_api_requests_in_flight",
+	))
+}
@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package cdio
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RetryPolicy controls how idempotent requests failing with a transient error are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts per request, values below 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the wait time before the first retry, it doubles with every further attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait time between two attempts.
+	MaxBackoff time.Duration
+	// Jitter is the fraction (0-1) of the backoff that is randomized to spread out retries.
+	Jitter float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Jitter:         0.2,
+}
+
+// Backoff returns the time to wait before the given retry attempt (starting at 1).
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(2, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff -= backoff * p.Jitter * rand.Float64()
+	}
+	return time.Duration(backoff)
+}
+
+func isIdempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number of seconds or a HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// do sends req and retries it according to the retry policy of the client. Only idempotent requests
+// failing with a network error or a transient status code (429, 502, 503, 504) are retried.
+func (client *ApiClient) do(endpoint string, req *http.Request) (*http.Response, error) {
+	policy := client.Retry
+	for attempt := 1; ; attempt++ {
+		done := client.Metrics.start(endpoint)
+		res, err := client.Client.Do(req)
+		if err != nil {
+			done(0)
+		} else {
+			done(res.StatusCode)
+		}
+
+		if req.Context().Err() != nil || !isIdempotent(req.Method) || attempt >= policy.MaxAttempts {
+			return res, err
+		}
+		if err == nil && !isRetryableStatus(res.StatusCode) {
+			return res, nil
+		}
+
+		wait := policy.Backoff(attempt)
+		if res != nil {
+			if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+				if policy.MaxBackoff > 0 && retryAfter > policy.MaxBackoff {
+					// the api asked us to back off longer than we are willing to wait
+					return res, nil
+				}
+				wait = retryAfter
+			}
+			// drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			log.Debugf("retrying %s in %s after status %d (attempt %d of %d)", req.URL.Path, wait, res.StatusCode, attempt+1, policy.MaxAttempts)
+		} else {
+			log.Debugf("retrying %s in %s after error %v (attempt %d of %d)", req.URL.Path, wait, err, attempt+1, policy.MaxAttempts)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package cdio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	testutil.Equals(t, 100*time.Millisecond, policy.Backoff(1))
+	testutil.Equals(t, 200*time.Millisecond, policy.Backoff(2))
+	testutil.Equals(t, 400*time.Millisecond, policy.Backoff(3))
+	testutil.Equals(t, time.Second, policy.Backoff(5))
+}
+
+func TestRetryPolicy_BackoffJitter(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: 0.5}
+
+	for i := 0; i < 100; i++ {
+		backoff := policy.Backoff(1)
+		testutil.Assert(t, backoff > 50*time.Millisecond && backoff <= 100*time.Millisecond, "backoff %s out of jitter range", backoff)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 4, 20, 12, 0, 0, 0, time.UTC)
+
+	wait, ok := parseRetryAfter("3", now)
+	testutil.Assert(t, ok, "expected seconds to be parsed")
+	testutil.Equals(t, 3*time.Second, wait)
+
+	wait, ok = parseRetryAfter("Sat, 20 Apr 2024 12:00:05 GMT", now)
+	testutil.Assert(t, ok, "expected http date to be parsed")
+	testutil.Equals(t, 5*time.Second, wait)
+
+	_, ok = parseRetryAfter("", now)
+	testutil.Assert(t, !ok, "expected empty header to be ignored")
+
+	_, ok = parseRetryAfter("whenever", now)
+	testutil.Assert(t, !ok, "expected invalid header to be ignored")
+}
+
+func TestRetry_RecoversFromTransientErrors(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		watchDb := testutil.NewWatchDb(2)
+		server := testutil.CreateTestApiServer(t, watchDb, testutil.WithFaults(testutil.Fault{
+			Path:   "/api/v1/watch",
+			Status: status,
+			Count:  2,
+		}))
+
+		api := NewTestApiClient(server.URL())
+		watches, err := api.GetWatches()
+
+		testutil.Ok(t, err)
+		testutil.Equals(t, 2, len(watches))
+		testutil.Equals(t, 3, server.RequestCount("/api/v1/watch"))
+		server.Close()
+	}
+}
+
+func TestRetry_GivesUpAfterMaxAttempts(t *testing.T) {
+	watchDb := testutil.NewWatchDb(2)
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithFaults(testutil.Fault{
+		Path:   "/api/v1/systeminfo",
+		Status: http.StatusServiceUnavailable,
+		Count:  10,
+	}))
+	defer server.Close()
+
+	api := NewTestApiClient(server.URL())
+	api.Retry.MaxAttempts = 4
+	_, err := api.GetSystemInfo()
+
+	testutil.Assert(t, err != nil, "expected error after exhausting all attempts")
+	testutil.Equals(t, 4, server.RequestCount("/api/v1/systeminfo"))
+}
+
+func TestRetry_DoesNotRetryPermanentErrors(t *testing.T) {
+	watchDb := testutil.NewWatchDb(2)
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithFaults(testutil.Fault{
+		Path:   "/api/v1/watch",
+		Status: http.StatusInternalServerError,
+		Count:  1,
+	}))
+	defer server.Close()
+
+	api := NewTestApiClient(server.URL())
+	_, err := api.GetWatches()
+
+	testutil.Assert(t, err != nil, "expected error for permanent failure")
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/watch"))
+}
+
+func TestRetry_DisabledWithSingleAttempt(t *testing.T) {
+	watchDb := testutil.NewWatchDb(2)
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithFaults(testutil.Fault{
+		Path:   "/api/v1/watch",
+		Status: http.StatusServiceUnavailable,
+		Count:  1,
+	}))
+	defer server.Close()
+
+	api := NewTestApiClient(server.URL())
+	api.Retry.MaxAttempts = 1
+	_, err := api.GetWatches()
+
+	testutil.Assert(t, err != nil, "expected error without retries")
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/watch"))
+}
+
+func TestRetry_RespectsRetryAfter(t *testing.T) {
+	watchDb := testutil.NewWatchDb(2)
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithFaults(testutil.Fault{
+		Path:       "/api/v1/watch",
+		Status:     http.StatusTooManyRequests,
+		RetryAfter: "1",
+		Count:      1,
+	}))
+	defer server.Close()
+
+	api := NewTestApiClient(server.URL())
+	api.Retry.MaxBackoff = 2 * time.Second
+	start := time.Now()
+	watches, err := api.GetWatches()
+
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(watches))
+	testutil.Assert(t, time.Since(start) >= time.Second, "expected client to wait for Retry-After, took %s", time.Since(start))
+}
+
+func TestRetry_GivesUpWhenRetryAfterExceedsMaxBackoff(t *testing.T) {
+	watchDb := testutil.NewWatchDb(2)
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithFaults(testutil.Fault{
+		Path:       "/api/v1/watch",
+		Status:     http.StatusTooManyRequests,
+		RetryAfter: "3600",
+		Count:      1,
+	}))
+	defer server.Close()
+
+	api := NewTestApiClient(server.URL())
+	_, err := api.GetWatches()
+
+	testutil.Assert(t, err != nil, "expected error when Retry-After is too long")
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/watch"))
+}
+
+func TestRetry_StopsWhenContextIsCancelled(t *testing.T) {
+	watchDb := testutil.NewWatchDb(2)
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithFaults(testutil.Fault{
+		Path:   "/api/v1/watch",
+		Status: http.StatusServiceUnavailable,
+		Count:  10,
+	}))
+	defer server.Close()
+
+	api := NewTestApiClient(server.URL())
+	api.Retry = RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Second, MaxBackoff: time.Second}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := api.GetWatchesContext(ctx)
+
+	testutil.Assert(t, errors.Is(err, context.DeadlineExceeded), "expected deadline exceeded, got %v", err)
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/watch"))
+}
@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package collectors
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/schaermu/changedetection.io-exporter/pkg/version"
+)
+
+// NewBuildInfoCollector returns a collector exposing the build information of the exporter.
+func NewBuildInfoCollector() prometheus.Collector {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "build_info",
+			Help:      "A metric with a constant '1' value labeled by the version, revision and go version the exporter was built from",
+			ConstLabels: prometheus.Labels{
+				"version":   version.Version,
+				"revision":  version.Revision,
+				"goversion": version.GoVersion,
+			},
+		},
+		func() float64 { return 1 },
+	)
+}
@@ -0,0 +1,23 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package collectors
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+	"github.com/schaermu/changedetection.io-exporter/pkg/version"
+)
+
+func TestBuildInfoCollector(t *testing.T) {
+	expected := fmt.Sprintf(`
+# HELP changedetectionio_exporter_build_info A metric with a constant '1' value labeled by the version, revision and go version the exporter was built from
+# TYPE changedetectionio_exporter_build_info gauge
+changedetectionio_exporter_build_info{goversion="%s",revision="%s",version="%s"} 1
+`, version.GoVersion, version.Revision, version.Version)
+
+	testutil.Ok(t, promtestutil.CollectAndCompare(NewBuildInfoCollector(), strings.NewReader(expected)))
+}
@@ -3,9 +3,14 @@
 package collectors
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
 	"sync"
+	"unicode"
 
-	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/data"
+	"github.com/schaermu/changedetection.io-exporter/pkg/rates"
 )
 
 var (
@@ -15,12 +20,150 @@ var (
 
 type baseCollector struct {
 	sync.RWMutex
+	collectorOptions
 
-	ApiClient *cdio.ApiClient
+	Store *Store
 }
 
-func newBaseCollector(client *cdio.ApiClient) *baseCollector {
+type collectorOptions struct {
+	tagLabel  *regexp.Regexp
+	uuidLabel bool
+
+	baseCurrency string
+	rates        *rates.File
+}
+
+// CollectorOption configures the labels of the metrics exposed for each watch.
+type CollectorOption func(*collectorOptions)
+
+// WithTagLabel adds a tag label to all watch metrics. Its value is the first tag of the watch (in
+// alphabetical order) matching pattern, or empty if none matches.
+func WithTagLabel(pattern *regexp.Regexp) CollectorOption {
+	return func(o *collectorOptions) {
+		o.tagLabel = pattern
+	}
+}
+
+// WithUuidLabel adds the id of the watch as uuid label to all watch metrics. Without it, watches
+// sharing their title and source are told apart by the first characters of their id appended to
+// the title.
+func WithUuidLabel() CollectorOption {
+	return func(o *collectorOptions) {
+		o.uuidLabel = true
+	}
+}
+
+// WithBaseCurrency additionally exposes the price of every offer converted to currency, using the
+// exchange rates of the given file.
+func WithBaseCurrency(currency string, rates *rates.File) CollectorOption {
+	return func(o *collectorOptions) {
+		o.baseCurrency = strings.ToUpper(currency)
+		o.rates = rates
+	}
+}
+
+func newCollectorOptions(options ...CollectorOption) collectorOptions {
+	opts := collectorOptions{}
+	for _, o := range options {
+		o(&opts)
+	}
+	return opts
+}
+
+func newBaseCollector(store *Store, options ...CollectorOption) *baseCollector {
 	return &baseCollector{
-		ApiClient: client,
+		collectorOptions: newCollectorOptions(options...),
+		Store:            store,
+	}
+}
+
+// identityLabelNames returns the names of the labels telling watches apart.
+func (c collectorOptions) identityLabelNames() []string {
+	names := append([]string{}, labels...)
+	if c.uuidLabel {
+		names = append(names, "uuid")
+	}
+	return names
+}
+
+// identityLabels returns the values of the labels named by identityLabelNames.
+func (c collectorOptions) identityLabels(snapshot *Snapshot, uuid string, watch *data.WatchItem) ([]string, error) {
+	values, err := watch.GetMetrics()
+	if err != nil {
+		return nil, err
+	}
+	if c.uuidLabel {
+		values = append(values, uuid)
+	} else if snapshot.Duplicates[uuid] {
+		values[0] = disambiguate(values[0], uuid)
+	}
+	return values, nil
+}
+
+// disambiguate appends the first characters of uuid to title, so watches sharing their title
+// and source still produce distinct series.
+func disambiguate(title string, uuid string) string {
+	short := uuid
+	if len(short) > 8 {
+		short = short[:8]
+	}
+	return fmt.Sprintf("%s (%s)", title, short)
+}
+
+// watchLabelNames returns the names of the labels of all watch metrics, followed by extra.
+func (c collectorOptions) watchLabelNames(extra ...string) []string {
+	names := c.identityLabelNames()
+	if c.tagLabel != nil {
+		names = append(names, "tag")
+	}
+	return append(names, extra...)
+}
+
+// watchLabels returns the values of the labels named by watchLabelNames, followed by extra.
+func (c collectorOptions) watchLabels(snapshot *Snapshot, uuid string, watch *data.WatchItem, extra ...string) ([]string, error) {
+	values, err := c.identityLabels(snapshot, uuid, watch)
+	if err != nil {
+		return nil, err
+	}
+	if c.tagLabel != nil {
+		values = append(values, c.matchingTag(snapshot, uuid))
+	}
+	return append(values, extra...), nil
+}
+
+func (c collectorOptions) matchingTag(snapshot *Snapshot, uuid string) string {
+	for _, name := range snapshot.TagNames(uuid) {
+		if c.tagLabel.MatchString(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// maxMessageLength limits the number of characters of messages exposed as label value.
+const maxMessageLength = 200
+
+// sanitizeMessage turns an arbitrary message into a short single-line label value: whitespace is
+// collapsed, invalid and non-printable characters are dropped and the result is truncated.
+func sanitizeMessage(message string) string {
+	message = strings.ToValidUTF8(message, "")
+	message = strings.Join(strings.Fields(message), " ")
+	message = strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, message)
+
+	if runes := []rune(message); len(runes) > maxMessageLength {
+		return string(runes[:maxMessageLength]) + "..."
+	}
+	return message
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
 	}
+	return 0
 }
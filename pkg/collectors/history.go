@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/data"
+	log "github.com/sirupsen/logrus"
+)
+
+// PriceStats contains statistics of the prices of all snapshots of a watch.
+type PriceStats struct {
+	// All covers every snapshot of the watch.
+	All PriceAggregate
+	// Window covers the snapshots taken within Duration before the refresh.
+	Window   PriceAggregate
+	Duration time.Duration
+}
+
+// PriceAggregate summarizes a series of prices. Min, Max and Mean are only meaningful if Count is
+// greater than 0.
+type PriceAggregate struct {
+	Min   float64
+	Max   float64
+	Mean  float64
+	Count int
+	// Changes is the number of snapshots whose price differs from the one of the snapshot before.
+	Changes int
+}
+
+// runningAggregate computes a PriceAggregate one price at a time.
+type runningAggregate struct {
+	min, max, sum float64
+	count         int
+	changes       int
+	last          float64
+}
+
+func (a *runningAggregate) add(price float64) {
+	if a.count == 0 {
+		a.min, a.max = price, price
+	} else {
+		a.min = min(a.min, price)
+		a.max = max(a.max, price)
+		if price != a.last {
+			a.changes++
+		}
+	}
+	a.sum += price
+	a.count++
+	a.last = price
+}
+
+func (a *runningAggregate) result() PriceAggregate {
+	if a.count == 0 {
+		return PriceAggregate{}
+	}
+	return PriceAggregate{Min: a.min, Max: a.max, Mean: a.sum / float64(a.count), Count: a.count, Changes: a.changes}
+}
+
+type pricePoint struct {
+	timestamp int64
+	price     float64
+}
+
+// PriceHistoryCache caches the price history of every watch of an instance. It can be shared by the
+// stores of consecutive exporters, so a reload of the configuration does not fetch every snapshot
+// again.
+type PriceHistoryCache struct {
+	mu      sync.Mutex
+	watches map[string]*priceHistory
+}
+
+func NewPriceHistoryCache() *PriceHistoryCache {
+	return &PriceHistoryCache{watches: make(map[string]*priceHistory)}
+}
+
+// get returns a copy of the price history of the watch with the given uuid, which can be updated
+// without holding the lock of the cache.
+func (c *PriceHistoryCache) get(uuid string) *priceHistory {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.watches[uuid]
+	if !ok {
+		return &priceHistory{}
+	}
+	history := *cached
+	history.points = slices.Clone(cached.points)
+	return &history
+}
+
+// put stores the price history of the watch with the given uuid, unless the cache holds a more
+// recent one (i.e. stored by another store sharing the cache).
+func (c *PriceHistoryCache) put(uuid string, history *priceHistory) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.watches[uuid]; ok && cached.latest > history.latest {
+		return
+	}
+	c.watches[uuid] = history
+}
+
+// forget removes the price history of all watches missing from watches.
+func (c *PriceHistoryCache) forget(watches map[string]*data.WatchItem) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for uuid := range c.watches {
+		if _, ok := watches[uuid]; !ok {
+			delete(c.watches, uuid)
+		}
+	}
+}
+
+// priceHistory caches the prices of the snapshots of a single watch, so every snapshot is only
+// fetched once.
+type priceHistory struct {
+	// lastChanged is the last change of the watch when its history was fetched.
+	lastChanged int64
+	// latest is the timestamp of the most recent snapshot processed.
+	latest int64
+	all    runningAggregate
+	// points contains the prices within the window, preceded by the last price before it.
+	points []pricePoint
+}
+
+// update fetches all snapshots taken since the last update and adds their prices.
+func (h *priceHistory) update(ctx context.Context, client *cdio.ApiClient, uuid string, lastChanged int64) error {
+	if lastChanged > 0 && lastChanged == h.lastChanged {
+		// no new snapshot since the last update
+		return nil
+	}
+
+	timestamps, err := client.GetHistoryContext(ctx, uuid)
+	if err != nil {
+		return err
+	}
+	for _, timestamp := range timestamps {
+		if timestamp <= h.latest {
+			continue
+		}
+
+		pData, err := client.GetPriceSnapshotContext(ctx, uuid, timestamp)
+		var decodeErr *cdio.DecodeError
+		if errors.As(err, &decodeErr) || errors.Is(err, cdio.ErrNotFound) {
+			// snapshots without an offer (i.e. while the page was broken) are skipped for good
+			log.Debugf("snapshot %d of watch %s has no price information: %v", timestamp, uuid, err)
+		} else if err != nil {
+			// keep the snapshots processed so far, the remaining ones are fetched next time
+			return fmt.Errorf("could not fetch snapshot %d: %w", timestamp, err)
+		} else {
+			h.all.add(pData.Price)
+			h.points = append(h.points, pricePoint{timestamp: timestamp, price: pData.Price})
+		}
+		h.latest = timestamp
+	}
+	h.lastChanged = lastChanged
+	return nil
+}
+
+// stats returns the statistics of all prices, dropping the prices that left the window ending at now.
+func (h *priceHistory) stats(window time.Duration, now time.Time) *PriceStats {
+	start := now.Add(-window).Unix()
+	for len(h.points) > 1 && h.points[1].timestamp < start {
+		h.points = h.points[1:]
+	}
+
+	var windowed runningAggregate
+	for i, point := range h.points {
+		if point.timestamp < start {
+			continue
+		}
+		windowed.add(point.price)
+		if i > 0 && windowed.count == 1 && h.points[i-1].price != point.price {
+			// the first price within the window changed compared to the one before
+			windowed.changes++
+		}
+	}
+	return &PriceStats{All: h.all.result(), Window: windowed.result(), Duration: window}
+}
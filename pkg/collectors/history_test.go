@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/data"
+)
+
+const day = 24 * time.Hour
+
+// newHistoryTestServer creates a test server with a single watch and the given price history.
+func newHistoryTestServer(t *testing.T, snapshots map[int64]*data.PriceData) (string, *testutil.ApiTestServer) {
+	watchDb := testutil.NewWatchDb(0)
+	uuid, watch := testutil.NewTestItem("Item 1", 110, "USD", 20, 15, 10)
+	watchDb[uuid] = watch
+	return uuid, testutil.CreateTestApiServer(t, watchDb, testutil.WithHistory(uuid, snapshots))
+}
+
+func TestPriceHistory_Stats(t *testing.T) {
+	now := time.Now()
+	h := &priceHistory{}
+	for _, p := range []pricePoint{
+		{now.Add(-10 * day).Unix(), 100},
+		{now.Add(-3 * day).Unix(), 80},
+		{now.Add(-2 * day).Unix(), 80},
+		{now.Add(-1 * day).Unix(), 110},
+	} {
+		h.all.add(p.price)
+		h.points = append(h.points, p)
+	}
+
+	stats := h.stats(7*day, now)
+
+	testutil.Equals(t, PriceAggregate{Min: 80, Max: 110, Mean: 92.5, Count: 4, Changes: 2}, stats.All)
+	// the first price within the window differs from the one before
+	testutil.Equals(t, PriceAggregate{Min: 80, Max: 110, Mean: 90, Count: 3, Changes: 2}, stats.Window)
+	testutil.Equals(t, 7*day, stats.Duration)
+}
+
+func TestPriceHistory_StatsDropsPricesLeavingTheWindow(t *testing.T) {
+	now := time.Now()
+	h := &priceHistory{}
+	for _, p := range []pricePoint{
+		{now.Add(-10 * day).Unix(), 100},
+		{now.Add(-9 * day).Unix(), 90},
+		{now.Add(-8 * day).Unix(), 90},
+	} {
+		h.all.add(p.price)
+		h.points = append(h.points, p)
+	}
+
+	stats := h.stats(7*day, now)
+
+	// only the last price before the window is kept
+	testutil.Equals(t, 1, len(h.points))
+	testutil.Equals(t, PriceAggregate{}, stats.Window)
+	testutil.Equals(t, 3, stats.All.Count)
+}
+
+func TestPriceHistory_UpdateFetchesSnapshotsOnce(t *testing.T) {
+	now := time.Now()
+	snapshots := map[int64]*data.PriceData{
+		now.Add(-2 * day).Unix(): {Price: 100, Currency: "USD"},
+		now.Add(-1 * day).Unix(): {Price: 90, Currency: "USD"},
+	}
+	uuid, server := newHistoryTestServer(t, snapshots)
+	defer server.Close()
+	client := cdio.NewTestApiClient(server.URL())
+
+	h := &priceHistory{}
+	testutil.Ok(t, h.update(context.Background(), client, uuid, 1))
+
+	// a new snapshot is the only one fetched
+	latest := now.Unix()
+	snapshots[latest] = &data.PriceData{Price: 95, Currency: "USD"}
+	testutil.Ok(t, h.update(context.Background(), client, uuid, 2))
+
+	for timestamp := range snapshots {
+		testutil.Equals(t, 1, server.RequestCount(fmt.Sprintf("/api/v1/watch/%s/history/%d", uuid, timestamp)))
+	}
+	testutil.Equals(t, PriceAggregate{Min: 90, Max: 100, Mean: 95, Count: 3, Changes: 2}, h.stats(7*day, now).All)
+
+	// without a change of the watch, the history is not requested again
+	testutil.Ok(t, h.update(context.Background(), client, uuid, 2))
+	testutil.Equals(t, 2, server.RequestCount(fmt.Sprintf("/api/v1/watch/%s/history", uuid)))
+}
+
+func TestPriceHistory_UpdateSkipsSnapshotsWithoutPrice(t *testing.T) {
+	now := time.Now()
+	broken, failing := now.Add(-2*day).Unix(), now.Add(-1*day).Unix()
+	watchDb := testutil.NewWatchDb(0)
+	uuid, watch := testutil.NewTestItem("Item 1", 110, "USD", 20, 15, 10)
+	watchDb[uuid] = watch
+	server := testutil.CreateTestApiServer(t, watchDb,
+		testutil.WithHistory(uuid, map[int64]*data.PriceData{
+			now.Add(-3 * day).Unix(): {Price: 100, Currency: "USD"},
+			broken:                   {Price: 0},
+			failing:                  {Price: 90, Currency: "USD"},
+		}),
+		testutil.WithFaults(
+			// a snapshot of a page without offer
+			testutil.Fault{Path: fmt.Sprintf("/api/v1/watch/%s/history/%d", uuid, broken), Status: http.StatusOK, Body: "<html></html>", Count: 1},
+			testutil.Fault{Path: fmt.Sprintf("/api/v1/watch/%s/history/%d", uuid, failing), Status: http.StatusBadRequest, Count: 1},
+		),
+	)
+	defer server.Close()
+	client := cdio.NewTestApiClient(server.URL())
+
+	h := &priceHistory{}
+	err := h.update(context.Background(), client, uuid, 1)
+	testutil.Assert(t, err != nil, "expected failing snapshot to be reported")
+	testutil.Equals(t, 1, h.all.result().Count)
+
+	// the failing snapshot is fetched again, the one without offer is not
+	testutil.Ok(t, h.update(context.Background(), client, uuid, 1))
+	testutil.Equals(t, 2, h.all.result().Count)
+	testutil.Equals(t, 1, server.RequestCount(fmt.Sprintf("/api/v1/watch/%s/history/%d", uuid, broken)))
+	testutil.Equals(t, 2, server.RequestCount(fmt.Sprintf("/api/v1/watch/%s/history/%d", uuid, failing)))
+}
+
+func TestPriceHistoryCache_KeepsMoreRecentHistory(t *testing.T) {
+	cache := NewPriceHistoryCache()
+	cache.put("uuid", &priceHistory{latest: 2, points: []pricePoint{{timestamp: 2, price: 90}}})
+
+	// histories are handed out as copies, so updating them does not touch the cache
+	history := cache.get("uuid")
+	history.points[0].price = 100
+	testutil.Equals(t, 90.0, cache.watches["uuid"].points[0].price)
+
+	// a history updated concurrently by another store does not replace a more recent one
+	cache.put("uuid", &priceHistory{latest: 1})
+	testutil.Equals(t, int64(2), cache.watches["uuid"].latest)
+
+	cache.forget(map[string]*data.WatchItem{})
+	testutil.Equals(t, 0, len(cache.watches))
+}
@@ -3,53 +3,200 @@
 package collectors
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/data"
+	"github.com/schaermu/changedetection.io-exporter/pkg/rates"
 	log "github.com/sirupsen/logrus"
 )
 
 type priceCollector struct {
 	baseCollector
 
-	price *prometheus.Desc
+	price         *prometheus.Desc
+	priceBase     *prometheus.Desc
+	originalPrice *prometheus.Desc
+	availability  *prometheus.Desc
+	priceMin      *prometheus.Desc
+	priceMax      *prometheus.Desc
+	priceMean     *prometheus.Desc
+	priceChanges  *prometheus.Desc
+	unconverted   *prometheus.Desc
 }
 
-func NewPriceCollector(client *cdio.ApiClient) *priceCollector {
+func NewPriceCollector(store *Store, options ...CollectorOption) *priceCollector {
+	opts := newCollectorOptions(options...)
 	return &priceCollector{
-		baseCollector: *newBaseCollector(client),
+		baseCollector: baseCollector{collectorOptions: opts, Store: store},
 		price: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "watch", "price"),
-			"Current price of an offer type watch",
-			labels, nil,
+			"Current price of a watch",
+			opts.watchLabelNames("currency"), nil,
+		),
+		priceBase: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "price_base"),
+			"Current price of a watch converted to the base currency",
+			opts.watchLabelNames("currency"), nil,
+		),
+		originalPrice: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "original_price"),
+			"Original price before a discount of a restock detection watch",
+			opts.watchLabelNames("currency"), nil,
+		),
+		availability: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "availability"),
+			"Availability of a watch, one series per schema.org availability with the current one set to '1'",
+			opts.watchLabelNames("availability"), nil,
+		),
+		priceMin: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "price_min"),
+			"Lowest price of all snapshots of a watch, or of the snapshots within the window",
+			opts.watchLabelNames("window"), nil,
+		),
+		priceMax: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "price_max"),
+			"Highest price of all snapshots of a watch, or of the snapshots within the window",
+			opts.watchLabelNames("window"), nil,
+		),
+		priceMean: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "price_mean"),
+			"Mean price of all snapshots of a watch, or of the snapshots within the window",
+			opts.watchLabelNames("window"), nil,
+		),
+		priceChanges: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "price_changes"),
+			"Number of price changes between all snapshots of a watch, or between the snapshots within the window",
+			opts.watchLabelNames("window"), nil,
+		),
+		unconverted: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter", "unconverted_prices"),
+			"Number of prices that could not be converted to the base currency, by their currency",
+			[]string{"currency"}, nil,
 		),
 	}
 }
 
 func (c *priceCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.price
+	ch <- c.priceBase
+	ch <- c.originalPrice
+	ch <- c.availability
+	ch <- c.priceMin
+	ch <- c.priceMax
+	ch <- c.priceMean
+	ch <- c.priceChanges
+	ch <- c.unconverted
 }
 
 func (c *priceCollector) Collect(ch chan<- prometheus.Metric) {
 	c.RLock()
 	defer c.RUnlock()
 
-	// check for new watches before collecting metrics
-	watches, err := c.ApiClient.GetWatches()
-	if err != nil {
-		log.Errorf("error while fetching watches: %v", err)
+	snapshot := c.Store.Snapshot()
+	var table *rates.Table
+	if c.rates != nil {
+		table = c.rates.Table()
 	}
+	unconverted := make(map[string]int)
+	for _, uuid := range snapshot.SortedUUIDs() {
+		watch := snapshot.Watches[uuid]
+		pData, hasPrice := snapshot.Prices[uuid]
+		restock, hasRestock := snapshot.Restocks[uuid]
+		if !hasPrice && !hasRestock {
+			continue
+		}
 
-	for uuid, watch := range watches {
-		// get latest price snapshot
-		if pData, err := c.ApiClient.GetLatestPriceSnapshot(uuid); err == nil {
-			if metricLabels, err := watch.GetMetrics(); err != nil {
-				log.Error(err)
-				continue
-			} else {
-				ch <- prometheus.MustNewConstMetric(c.price, prometheus.GaugeValue, pData.Price, metricLabels...)
+		if metricLabels, err := c.watchLabels(snapshot, uuid, watch); err != nil {
+			log.Error(err)
+			continue
+		} else if hasRestock {
+			if restock.Price != nil {
+				c.collectPrice(ch, table, unconverted, uuid, metricLabels, *restock.Price, restock.Currency)
+			}
+			if restock.OriginalPrice != nil {
+				originalLabels := append(append([]string{}, metricLabels...), normalizeCurrency(restock.Currency))
+				ch <- prometheus.MustNewConstMetric(c.originalPrice, prometheus.GaugeValue, *restock.OriginalPrice, originalLabels...)
 			}
+			current, _ := restock.Availability()
+			c.collectAvailability(ch, metricLabels, current)
 		} else {
-			log.Error(err)
+			c.collectPrice(ch, table, unconverted, uuid, metricLabels, pData.Price, pData.Currency)
+
+			current, ok := pData.NormalizedAvailability()
+			if !ok && pData.Availability != "" {
+				log.Debugf("unknown availability %q of watch %s", pData.Availability, uuid)
+			}
+			c.collectAvailability(ch, metricLabels, current)
+
+			if stats, ok := snapshot.PriceStats[uuid]; ok {
+				c.collectStats(ch, metricLabels, "all", stats.All)
+				c.collectStats(ch, metricLabels, formatWindow(stats.Duration), stats.Window)
+			}
 		}
 	}
+	for currency, count := range unconverted {
+		ch <- prometheus.MustNewConstMetric(c.unconverted, prometheus.GaugeValue, float64(count), currency)
+	}
+}
+
+// collectStats exposes the statistics of a price aggregate, the price ones only if it has prices.
+func (c *priceCollector) collectStats(ch chan<- prometheus.Metric, metricLabels []string, window string, aggregate PriceAggregate) {
+	windowLabels := append(append([]string{}, metricLabels...), window)
+	if aggregate.Count > 0 {
+		ch <- prometheus.MustNewConstMetric(c.priceMin, prometheus.GaugeValue, aggregate.Min, windowLabels...)
+		ch <- prometheus.MustNewConstMetric(c.priceMax, prometheus.GaugeValue, aggregate.Max, windowLabels...)
+		ch <- prometheus.MustNewConstMetric(c.priceMean, prometheus.GaugeValue, aggregate.Mean, windowLabels...)
+	}
+	ch <- prometheus.MustNewConstMetric(c.priceChanges, prometheus.GaugeValue, float64(aggregate.Changes), windowLabels...)
+}
+
+// formatWindow formats d in the shortest way Prometheus accepts as duration (i.e. 7d or 1h30m).
+func formatWindow(d time.Duration) string {
+	const day = 24 * time.Hour
+	if d > 0 && d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+	formatted := d.String()
+	if strings.HasSuffix(formatted, "m0s") {
+		formatted = strings.TrimSuffix(formatted, "0s")
+	}
+	if strings.HasSuffix(formatted, "h0m") {
+		formatted = strings.TrimSuffix(formatted, "0m")
+	}
+	return formatted
+}
+
+// collectPrice exposes price, and its conversion to the base currency if a rates table is given.
+// Prices that cannot be converted are counted by their currency in unconverted.
+func (c *priceCollector) collectPrice(ch chan<- prometheus.Metric, table *rates.Table, unconverted map[string]int, uuid string, metricLabels []string, price float64, currency string) {
+	currency = normalizeCurrency(currency)
+	priceLabels := append(append([]string{}, metricLabels...), currency)
+	ch <- prometheus.MustNewConstMetric(c.price, prometheus.GaugeValue, price, priceLabels...)
+
+	if table == nil {
+		return
+	}
+	if converted, err := table.Convert(price, currency, c.baseCurrency); err != nil {
+		// logged at debug level only, as it recurs on every scrape
+		log.Debugf("could not convert price of watch %s: %v", uuid, err)
+		unconverted[currency]++
+	} else {
+		baseLabels := append(append([]string{}, metricLabels...), c.baseCurrency)
+		ch <- prometheus.MustNewConstMetric(c.priceBase, prometheus.GaugeValue, converted, baseLabels...)
+	}
+}
+
+// collectAvailability exposes one series per availability, the current one set to 1.
+func (c *priceCollector) collectAvailability(ch chan<- prometheus.Metric, metricLabels []string, current string) {
+	for _, availability := range data.Availabilities {
+		stateLabels := append(append([]string{}, metricLabels...), availability)
+		ch <- prometheus.MustNewConstMetric(c.availability, prometheus.GaugeValue, boolToFloat(availability == current), stateLabels...)
+	}
+}
+
+func normalizeCurrency(currency string) string {
+	return strings.ToUpper(strings.TrimSpace(currency))
 }
@@ -3,10 +3,14 @@
 package collectors
 
 import (
+	"context"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
-	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/data"
+	"github.com/schaermu/changedetection.io-exporter/pkg/rates"
 )
 
 var (
@@ -18,24 +22,151 @@ func TestPriceCollector(t *testing.T) {
 	server := testutil.CreateTestApiServer(t, watchDb)
 	defer server.Close()
 
-	client := cdio.NewTestApiClient(server.URL())
-	c := NewPriceCollector(client)
+	store := newTestStore(server)
+	c := NewPriceCollector(store)
 
 	testutil.ExpectMetricCount(t, c, 2, expectedPriceMetrics...)
 	testutil.ExpectMetrics(t, c, "price_metrics.prom", expectedPriceMetrics...)
 }
 
+func TestPriceCollector_TagLabel(t *testing.T) {
+	server := newTaggedTestServer(t)
+	defer server.Close()
+
+	c := NewPriceCollector(newTestStore(server), WithTagLabel(regexp.MustCompile("^vendor:")))
+
+	testutil.ExpectMetrics(t, c, "price_tag_metrics.prom", expectedPriceMetrics...)
+}
+
+func TestPriceCollector_Availability(t *testing.T) {
+	watchDb := testutil.NewWatchDb(0)
+	uuid1, watch1 := testutil.NewTestItem("Item 1", 100, "USD", 20, 15, 10)
+	watch1.PriceData.Availability = "https://schema.org/OutOfStock"
+	watchDb[uuid1] = watch1
+	// unknown availabilities expose all states as 0
+	uuid2, watch2 := testutil.NewTestItem("Item 2", 200, "USD", 20, 15, 10)
+	watch2.PriceData.Availability = "SoldOut"
+	watchDb[uuid2] = watch2
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	c := NewPriceCollector(newTestStore(server))
+
+	testutil.ExpectMetrics(t, c, "price_availability_metrics.prom", "changedetectionio_watch_availability")
+}
+
+func TestPriceCollector_BaseCurrency(t *testing.T) {
+	watchDb := testutil.NewWatchDb(0)
+	uuid1, watch1 := testutil.NewTestItem("Item 1", 100, "USD", 20, 15, 10)
+	watchDb[uuid1] = watch1
+	uuid2, watch2 := testutil.NewTestItem("Item 2", 200, "chf", 20, 15, 10)
+	watchDb[uuid2] = watch2
+	// prices with a missing or unknown currency are not converted
+	uuid3, watch3 := testutil.NewTestItem("Item 3", 300, "", 20, 15, 10)
+	watchDb[uuid3] = watch3
+	uuid4, watch4 := testutil.NewTestItem("Item 4", 400, "XYZ", 20, 15, 10)
+	watchDb[uuid4] = watch4
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	table, err := rates.NewFile(testutil.GetFixturePath("rates/rates.yml"))
+	testutil.Ok(t, err)
+	c := NewPriceCollector(newTestStore(server), WithBaseCurrency("chf", table))
+
+	testutil.ExpectMetrics(t, c, "price_base_metrics.prom",
+		"changedetectionio_watch_price",
+		"changedetectionio_watch_price_base",
+		"changedetectionio_exporter_unconverted_prices",
+	)
+}
+
+func TestPriceCollector_Restock(t *testing.T) {
+	watchDb := testutil.NewWatchDb(0)
+	uuid1, watch1 := testutil.NewTestItem("Item 1", 100, "USD", 20, 15, 10)
+	watchDb[uuid1] = watch1
+	uuid2, watch2 := testutil.NewTestRestockItem("Item 2", 19.9, "chf", true)
+	originalPrice := 24.9
+	watch2.Restock.OriginalPrice = &originalPrice
+	watchDb[uuid2] = watch2
+	// the price of restock watches is optional
+	uuid3, watch3 := testutil.NewTestRestockItem("Item 3", 0, "", false)
+	watch3.Restock.Price = nil
+	watchDb[uuid3] = watch3
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	c := NewPriceCollector(newTestStore(server))
+
+	testutil.ExpectMetrics(t, c, "price_restock_metrics.prom",
+		"changedetectionio_watch_price",
+		"changedetectionio_watch_original_price",
+		"changedetectionio_watch_availability",
+	)
+}
+
+func TestPriceCollector_PriceStats(t *testing.T) {
+	now := time.Now()
+	watchDb := testutil.NewWatchDb(0)
+	uuid1, watch1 := testutil.NewTestItem("Item 1", 110, "USD", 20, 15, 10)
+	watchDb[uuid1] = watch1
+	// watches without snapshots only expose the number of changes
+	uuid2, watch2 := testutil.NewTestItem("Item 2", 200, "USD", 20, 15, 10)
+	watchDb[uuid2] = watch2
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithHistory(uuid1, map[int64]*data.PriceData{
+		now.Add(-10 * 24 * time.Hour).Unix(): {Price: 100, Currency: "USD"},
+		now.Add(-3 * 24 * time.Hour).Unix():  {Price: 80, Currency: "USD"},
+		now.Add(-2 * 24 * time.Hour).Unix():  {Price: 80, Currency: "USD"},
+		now.Add(-1 * 24 * time.Hour).Unix():  {Price: 110, Currency: "USD"},
+	}))
+	defer server.Close()
+
+	c := NewPriceCollector(newTestStore(server, WithPriceHistory(NewPriceHistoryCache(), 7*24*time.Hour)))
+
+	testutil.ExpectMetrics(t, c, "price_stats_metrics.prom",
+		"changedetectionio_watch_price_min",
+		"changedetectionio_watch_price_max",
+		"changedetectionio_watch_price_mean",
+		"changedetectionio_watch_price_changes",
+	)
+}
+
+func TestFormatWindow(t *testing.T) {
+	testutil.Equals(t, "7d", formatWindow(7*24*time.Hour))
+	testutil.Equals(t, "12h", formatWindow(12*time.Hour))
+	testutil.Equals(t, "1h30m", formatWindow(90*time.Minute))
+	testutil.Equals(t, "30s", formatWindow(30*time.Second))
+}
+
+func TestPriceCollector_SkipPaused(t *testing.T) {
+	watchDb := testutil.NewWatchDb(0)
+	uuid1, watch1 := testutil.NewTestItem("Item 1", 100, "USD", 20, 15, 10)
+	watchDb[uuid1] = watch1
+	uuid2, watch2 := testutil.NewTestItem("Item 2", 200, "USD", 20, 15, 10)
+	watch2.Paused = true
+	watchDb[uuid2] = watch2
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	testutil.ExpectMetricCount(t, NewPriceCollector(newTestStore(server)), 2, expectedPriceMetrics...)
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/watch/"+uuid2+"/history/latest"))
+
+	// the prices of paused watches are not fetched at all
+	testutil.ExpectMetricCount(t, NewPriceCollector(newTestStore(server, WithSkipPaused())), 1, expectedPriceMetrics...)
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/watch/"+uuid2+"/history/latest"))
+}
+
 func TestPriceCollector_RemoveWatchDuringRuntime(t *testing.T) {
 	keyToRemove, watchDb := testutil.NewCollectorTestDb()
 	server := testutil.CreateTestApiServer(t, watchDb)
 	defer server.Close()
 
-	client := cdio.NewTestApiClient(server.URL())
-	c := NewPriceCollector(client)
+	store := newTestStore(server)
+	c := NewPriceCollector(store)
 
 	testutil.ExpectMetricCount(t, c, 2, expectedPriceMetrics...)
 
 	delete(watchDb, keyToRemove)
+	store.Refresh(context.Background())
 
 	testutil.ExpectMetricCount(t, c, 1, expectedPriceMetrics...)
 	testutil.ExpectMetrics(t, c, "price_metrics_autounregister.prom", expectedPriceMetrics...)
@@ -46,14 +177,15 @@ func TestPriceCollector_NewWatchDuringRuntime(t *testing.T) {
 	server := testutil.CreateTestApiServer(t, watchDb)
 	defer server.Close()
 
-	client := cdio.NewTestApiClient(server.URL())
-	c := NewPriceCollector(client)
+	store := newTestStore(server)
+	c := NewPriceCollector(store)
 
 	testutil.ExpectMetricCount(t, c, 2, expectedPriceMetrics...)
 
 	// now add a new watch and expect the collector to pick it up
 	uuid, newItem := testutil.NewTestItem("Item 3", 300, "USD", 0, 0, 0)
 	watchDb[uuid] = newItem
+	store.Refresh(context.Background())
 
 	testutil.ExpectMetricCount(t, c, 3, expectedPriceMetrics...)
 	testutil.ExpectMetrics(t, c, "price_metrics_autoregister.prom", expectedPriceMetrics...)
@@ -66,8 +198,8 @@ func TestPriceCollector_IgnoresWatchesWithoutTitle(t *testing.T) {
 	watchDb[emptyUuid] = emptyTitleItem
 	defer server.Close()
 
-	client := cdio.NewTestApiClient(server.URL())
-	c := NewPriceCollector(client)
+	store := newTestStore(server)
+	c := NewPriceCollector(store)
 
 	testutil.ExpectMetrics(t, c, "price_metrics.prom", expectedPriceMetrics...)
 }
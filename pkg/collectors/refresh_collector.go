@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package collectors
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type refreshCollector struct {
+	baseCollector
+
+	snapshotAge       *prometheus.Desc
+	refreshDuration   *prometheus.Desc
+	up                *prometheus.Desc
+	collectorSuccess  *prometheus.Desc
+	collectorDuration *prometheus.Desc
+	labelCollisions   *prometheus.Desc
+}
+
+func NewRefreshCollector(store *Store) *refreshCollector {
+	return &refreshCollector{
+		baseCollector: *newBaseCollector(store),
+		snapshotAge: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter", "snapshot_age_seconds"),
+			"Age of the cached changedetection.io data snapshot",
+			nil, nil,
+		),
+		refreshDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter", "last_refresh_duration_seconds"),
+			"Time it took to refresh the changedetection.io data snapshot",
+			nil, nil,
+		),
+		up: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "up"),
+			"Whether the changedetection.io instance could be reached during the last refresh",
+			nil, nil,
+		),
+		collectorSuccess: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "scrape", "collector_success"),
+			"Whether the data of a collector could be fetched completely during the last refresh",
+			[]string{"collector"}, nil,
+		),
+		collectorDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "scrape", "collector_duration_seconds"),
+			"Time it took to fetch the data of a collector during the last refresh",
+			[]string{"collector"}, nil,
+		),
+		labelCollisions: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "exporter", "label_collisions"),
+			"Number of watches sharing their title and source with another watch during the last refresh",
+			nil, nil,
+		),
+	}
+}
+
+func (c *refreshCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.snapshotAge
+	ch <- c.refreshDuration
+	ch <- c.up
+	ch <- c.collectorSuccess
+	ch <- c.collectorDuration
+	ch <- c.labelCollisions
+}
+
+func (c *refreshCollector) Collect(ch chan<- prometheus.Metric) {
+	c.RLock()
+	defer c.RUnlock()
+
+	snapshot := c.Store.Snapshot()
+	if snapshot.Timestamp.IsZero() {
+		// no refresh has finished yet
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.snapshotAge, prometheus.GaugeValue, time.Since(snapshot.Timestamp).Seconds())
+	ch <- prometheus.MustNewConstMetric(c.refreshDuration, prometheus.GaugeValue, snapshot.Duration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, boolToFloat(snapshot.Up))
+	ch <- prometheus.MustNewConstMetric(c.labelCollisions, prometheus.GaugeValue, float64(len(snapshot.Duplicates)))
+
+	for _, name := range CollectorNames() {
+		result := snapshot.Results[name]
+		ch <- prometheus.MustNewConstMetric(c.collectorSuccess, prometheus.GaugeValue, boolToFloat(result.Err == nil), name)
+		ch <- prometheus.MustNewConstMetric(c.collectorDuration, prometheus.GaugeValue, result.Duration.Seconds(), name)
+	}
+}
@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package collectors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+)
+
+var (
+	expectedRefreshMetrics = []string{
+		"changedetectionio_exporter_snapshot_age_seconds",
+		"changedetectionio_exporter_last_refresh_duration_seconds",
+		"changedetectionio_up",
+		"changedetectionio_exporter_label_collisions",
+	}
+	expectedScrapeMetrics = []string{
+		"changedetectionio_scrape_collector_success",
+		"changedetectionio_scrape_collector_duration_seconds",
+	}
+	expectedStatusMetrics = []string{
+		"changedetectionio_scrape_collector_success",
+		"changedetectionio_up",
+	}
+)
+
+func TestRefreshCollector(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	store := newTestStore(server)
+	c := NewRefreshCollector(store)
+
+	testutil.ExpectMetricCount(t, c, 1, expectedRefreshMetrics...)
+	testutil.ExpectMetricCount(t, c, 3, expectedScrapeMetrics...)
+	testutil.ExpectMetrics(t, c, "refresh_metrics.prom", expectedStatusMetrics...)
+}
+
+func TestRefreshCollector_Unreachable(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb)
+	server.Close()
+
+	client := cdio.NewTestApiClient(server.URL())
+	client.Retry.MaxAttempts = 1
+	store := NewStore(client)
+	store.Refresh(context.Background())
+	c := NewRefreshCollector(store)
+
+	testutil.ExpectMetrics(t, c, "refresh_metrics_unreachable.prom", expectedStatusMetrics...)
+}
+
+func TestRefreshCollector_FailingWatch(t *testing.T) {
+	failingId, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithFailingWatches(failingId))
+	defer server.Close()
+
+	c := NewRefreshCollector(newTestStore(server))
+
+	testutil.ExpectMetrics(t, c, "refresh_metrics_failing_watch.prom", expectedStatusMetrics...)
+}
+
+func TestRefreshCollector_NoMetricsBeforeFirstRefresh(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	store := NewStore(cdio.NewTestApiClient(server.URL()))
+	c := NewRefreshCollector(store)
+
+	testutil.ExpectMetricCount(t, c, 0, expectedRefreshMetrics...)
+	testutil.ExpectMetricCount(t, c, 0, expectedScrapeMetrics...)
+}
+
+func TestRefreshCollector_LabelCollisions(t *testing.T) {
+	server := newDuplicateTestServer(t)
+	defer server.Close()
+
+	c := NewRefreshCollector(newTestStore(server))
+
+	testutil.ExpectMetrics(t, c, "refresh_metrics_collisions.prom", "changedetectionio_exporter_label_collisions")
+}
@@ -0,0 +1,562 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package collectors
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/data"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	systemCollectorName = "system"
+	watchCollectorName  = "watch"
+	priceCollectorName  = "price"
+)
+
+// CollectorNames returns the names of all collectors reading from the store, in the order their
+// data is fetched during a refresh.
+func CollectorNames() []string {
+	return []string{systemCollectorName, watchCollectorName, priceCollectorName}
+}
+
+// FetchResult describes how fetching the data of a single collector went during a refresh.
+type FetchResult struct {
+	Duration time.Duration
+	// Err is the first error that occurred, nil if all data has been fetched successfully.
+	Err error
+}
+
+// Snapshot contains all data fetched from the changedetection.io API during a single refresh.
+type Snapshot struct {
+	Watches      map[string]*data.WatchItem
+	WatchDetails map[string]*data.WatchItem
+	Prices       map[string]*data.PriceData
+	// Restocks contains the state of watches using the restock detection processor, which have
+	// no price snapshot.
+	Restocks map[string]*data.RestockData
+	// PriceStats contains the statistics of the price history of watches, if enabled.
+	PriceStats map[string]*PriceStats
+	Tags       map[string]*data.Tag
+	SystemInfo *data.SystemInfo
+	// Duplicates contains the ids of all watches sharing their title and source with another watch.
+	Duplicates map[string]bool
+
+	// Up reports whether the changedetection.io instance answered the core requests of the refresh.
+	Up bool
+	// listed reports whether the list of watches could be fetched.
+	listed bool
+	// Results contains the fetch result of each collector, keyed by collector name.
+	Results map[string]*FetchResult
+
+	Timestamp time.Time
+	Duration  time.Duration
+}
+
+func newSnapshot() *Snapshot {
+	return &Snapshot{
+		Watches:      make(map[string]*data.WatchItem),
+		WatchDetails: make(map[string]*data.WatchItem),
+		Prices:       make(map[string]*data.PriceData),
+		Restocks:     make(map[string]*data.RestockData),
+		PriceStats:   make(map[string]*PriceStats),
+		Tags:         make(map[string]*data.Tag),
+		Duplicates:   make(map[string]bool),
+		Results: map[string]*FetchResult{
+			systemCollectorName: {},
+			watchCollectorName:  {},
+			priceCollectorName:  {},
+		},
+	}
+}
+
+// Processor returns the processor of the watch with the given uuid, preferring its details over
+// the list of watches.
+func (s *Snapshot) Processor(uuid string) string {
+	if watch, ok := s.WatchDetails[uuid]; ok {
+		return watch.Processor
+	}
+	if watch, ok := s.Watches[uuid]; ok {
+		return watch.Processor
+	}
+	return ""
+}
+
+// SortedUUIDs returns the ids of all watches in the snapshot in a stable order.
+func (s *Snapshot) SortedUUIDs() []string {
+	uuids := make([]string, 0, len(s.Watches))
+	for uuid := range s.Watches {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+	return uuids
+}
+
+// findDuplicates marks all watches whose labels are identical to the ones of another watch.
+func (s *Snapshot) findDuplicates() {
+	byLabels := make(map[[2]string][]string)
+	for uuid, watch := range s.Watches {
+		if metricLabels, err := watch.GetMetrics(); err == nil {
+			key := [2]string{metricLabels[0], metricLabels[1]}
+			byLabels[key] = append(byLabels[key], uuid)
+		}
+	}
+	for _, uuids := range byLabels {
+		if len(uuids) > 1 {
+			for _, uuid := range uuids {
+				s.Duplicates[uuid] = true
+			}
+		}
+	}
+}
+
+// TagNames returns the sorted names of all tags of a watch. Tags that are unknown to the snapshot
+// (i.e. because fetching the tags failed) are left out.
+func (s *Snapshot) TagNames(uuid string) []string {
+	watch, ok := s.WatchDetails[uuid]
+	if !ok {
+		if watch, ok = s.Watches[uuid]; !ok {
+			return nil
+		}
+	}
+
+	var names []string
+	for _, tagUuid := range watch.Tags {
+		if tag, ok := s.Tags[tagUuid]; ok && tag.Title != "" {
+			names = append(names, tag.Title)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Store keeps the latest snapshot of the changedetection.io API in memory and shares it between
+// all collectors. It is either refreshed in the background (see Run) or lazily on read once the
+// snapshot is older than its max age (see WithMaxAge).
+type Store struct {
+	sync.RWMutex
+	refreshMu sync.Mutex
+
+	ApiClient   *cdio.ApiClient
+	concurrency int
+	maxAge      time.Duration
+	skipPaused  bool
+	snapshot    *Snapshot
+	ready       chan struct{}
+	readyOnce   sync.Once
+
+	// history caches the price history of each watch across refreshes.
+	history       *PriceHistoryCache
+	historyMu     sync.Mutex
+	historyWindow time.Duration
+}
+
+type StoreOption func(*Store)
+
+// WithConcurrency sets the number of watches fetched in parallel during a refresh.
+func WithConcurrency(concurrency int) StoreOption {
+	return func(s *Store) {
+		if concurrency > 0 {
+			s.concurrency = concurrency
+		}
+	}
+}
+
+// WithMaxAge makes the store refresh the snapshot on read once it is older than maxAge, so all
+// collectors of a scrape share the same API requests.
+func WithMaxAge(maxAge time.Duration) StoreOption {
+	return func(s *Store) {
+		s.maxAge = maxAge
+	}
+}
+
+// WithSkipPaused leaves paused watches out of the snapshot, so neither their details, prices nor
+// price history are fetched and they are omitted from all watch and price metrics.
+func WithSkipPaused() StoreOption {
+	return func(s *Store) {
+		s.skipPaused = true
+	}
+}
+
+// WithPriceHistory makes the store compute statistics of the prices of all snapshots of every
+// watch, and of the snapshots taken within window. Every snapshot is fetched only once and kept in
+// cache, which may be shared with other stores of the same instance.
+func WithPriceHistory(cache *PriceHistoryCache, window time.Duration) StoreOption {
+	return func(s *Store) {
+		s.history = cache
+		s.historyWindow = window
+	}
+}
+
+func NewStore(client *cdio.ApiClient, options ...StoreOption) *Store {
+	store := &Store{
+		ApiClient:   client,
+		concurrency: 4,
+		snapshot:    newSnapshot(),
+		ready:       make(chan struct{}),
+	}
+	for _, o := range options {
+		o(store)
+	}
+	return store
+}
+
+// Snapshot returns the latest snapshot, refreshing it first if the store has a max age configured
+// and the snapshot is stale. The returned snapshot must be treated as read-only.
+func (s *Store) Snapshot() *Snapshot {
+	if s.maxAge > 0 {
+		s.RefreshIfStale(context.Background())
+	}
+	return s.Latest()
+}
+
+// Latest returns the latest snapshot without refreshing it.
+func (s *Store) Latest() *Snapshot {
+	s.RLock()
+	defer s.RUnlock()
+	return s.snapshot
+}
+
+// RefreshIfStale refreshes the snapshot if the store has a max age configured and the snapshot is
+// older than that. Concurrent callers wait for the same refresh instead of starting their own.
+func (s *Store) RefreshIfStale(ctx context.Context) {
+	if s.maxAge <= 0 {
+		return
+	}
+
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	if time.Since(s.Latest().Timestamp) < s.maxAge {
+		return
+	}
+	s.set(s.fetch(ctx))
+	if s.history != nil {
+		// scrapes don't wait for the price history, a later one exposes the updated statistics
+		go s.updatePriceStats(context.WithoutCancel(ctx))
+	}
+}
+
+// Refresh fetches all data from the API and replaces the current snapshot, which is published
+// before the price history is updated. If ctx is cancelled during the refresh, the snapshot
+// contains only the data fetched until then.
+func (s *Store) Refresh(ctx context.Context) {
+	s.refreshMu.Lock()
+	s.set(s.fetch(ctx))
+	s.refreshMu.Unlock()
+	s.updatePriceStats(ctx)
+}
+
+func (s *Store) set(snapshot *Snapshot) {
+	s.Lock()
+	defer s.Unlock()
+	s.snapshot = snapshot
+	s.readyOnce.Do(func() { close(s.ready) })
+}
+
+// Ready returns a channel that is closed once the store holds its first snapshot.
+func (s *Store) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// fetch loads all data from the API into a new snapshot.
+func (s *Store) fetch(ctx context.Context) *Snapshot {
+	start := time.Now()
+	snapshot := newSnapshot()
+
+	// get system info
+	system := snapshot.Results[systemCollectorName]
+	systemInfo, err := s.ApiClient.GetSystemInfoContext(ctx)
+	if err != nil {
+		log.Errorf("error while fetching system info (%s): %v", cdio.ErrorReason(err), err)
+		system.Err = err
+	} else {
+		snapshot.SystemInfo = systemInfo
+	}
+	system.Duration = time.Since(start)
+
+	// get list of all watches
+	listStart := time.Now()
+	watches, listErr := s.ApiClient.GetWatchesContext(ctx)
+	if listErr != nil {
+		log.Errorf("error while fetching watches (%s): %v", cdio.ErrorReason(listErr), listErr)
+	}
+	listDuration := time.Since(listStart)
+	for uuid, watch := range watches {
+		if s.skipPaused && watch.Paused {
+			continue
+		}
+		snapshot.Watches[uuid] = watch
+	}
+	uuids := snapshot.SortedUUIDs()
+	snapshot.findDuplicates()
+	if len(snapshot.Duplicates) > 0 {
+		log.Warnf("%d watches share their title and source with another watch", len(snapshot.Duplicates))
+	}
+	snapshot.listed = listErr == nil
+	snapshot.Up = system.Err == nil && snapshot.listed
+
+	var mu sync.Mutex
+
+	// get tags to resolve the tag ids of the watches
+	detailStart := time.Now()
+	watch := snapshot.Results[watchCollectorName]
+	var tags map[string]*data.Tag
+	var tagErr error
+	if listErr == nil {
+		tags, tagErr = s.ApiClient.GetTagsContext(ctx)
+	}
+	if errors.Is(tagErr, cdio.ErrNotFound) {
+		// changedetection.io versions before tag support
+		log.Debugf("tags are not supported by the instance: %v", tagErr)
+		tagErr = nil
+	} else if tagErr != nil {
+		log.Errorf("error while fetching tags (%s): %v", cdio.ErrorReason(tagErr), tagErr)
+	}
+	for uuid, tag := range tags {
+		snapshot.Tags[uuid] = tag
+	}
+
+	// get latest watch data
+	watch.Err = s.forEachWatch(ctx, uuids, func(uuid string) error {
+		watchData, err := s.ApiClient.GetWatchDataContext(ctx, uuid)
+		if errors.Is(err, cdio.ErrNotFound) {
+			// watch was removed since fetching the list
+			return nil
+		} else if err != nil {
+			log.Errorf("error while fetching watch %s (%s): %v", uuid, cdio.ErrorReason(err), err)
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		snapshot.WatchDetails[uuid] = watchData
+		return nil
+	})
+	watch.Duration = listDuration + time.Since(detailStart)
+	if watch.Err == nil {
+		watch.Err = tagErr
+	}
+	if s.skipPaused {
+		// the details are more recent than the watch list
+		for uuid, details := range snapshot.WatchDetails {
+			if details.Paused {
+				delete(snapshot.Watches, uuid)
+				delete(snapshot.WatchDetails, uuid)
+			}
+		}
+		uuids = snapshot.SortedUUIDs()
+	}
+
+	// get latest price snapshots
+	priceStart := time.Now()
+	price := snapshot.Results[priceCollectorName]
+	price.Err = s.forEachWatch(ctx, uuids, func(uuid string) error {
+		if snapshot.Processor(uuid) == data.ProcessorRestockDiff {
+			// the snapshots of restock watches contain no offer, their state is part of the details
+			if details, ok := snapshot.WatchDetails[uuid]; ok && details.Restock != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				snapshot.Restocks[uuid] = details.Restock
+			}
+			return nil
+		}
+
+		pData, err := s.ApiClient.GetLatestPriceSnapshotContext(ctx, uuid)
+		var decodeErr *cdio.DecodeError
+		if errors.As(err, &decodeErr) || errors.Is(err, cdio.ErrNotFound) {
+			// watches without an offer or without any snapshot yet are expected
+			log.Debugf("watch %s has no price information: %v", uuid, err)
+			return nil
+		} else if err != nil {
+			log.Errorf("error while fetching price of watch %s (%s): %v", uuid, cdio.ErrorReason(err), err)
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		snapshot.Prices[uuid] = pData
+		return nil
+	})
+	if s.history != nil {
+		// the statistics of the previous snapshot are kept until the price history is updated
+		for uuid, stats := range s.Latest().PriceStats {
+			if _, ok := snapshot.Prices[uuid]; ok {
+				snapshot.PriceStats[uuid] = stats
+			}
+		}
+	}
+	price.Duration = listDuration + time.Since(priceStart)
+
+	if listErr != nil {
+		// without the list of watches, neither watch nor price data is complete
+		watch.Err = listErr
+		price.Err = listErr
+	}
+
+	snapshot.Timestamp = time.Now()
+	snapshot.Duration = snapshot.Timestamp.Sub(start)
+	log.Debugf("refreshed snapshot of %d watches in %s", len(snapshot.Watches), snapshot.Duration)
+	return snapshot
+}
+
+// updatePriceStats updates the cached price history of every watch with a price in the latest
+// snapshot, and publishes the statistics in a copy of the snapshot. An update already in progress
+// makes it return immediately, as both would fetch the same snapshots.
+func (s *Store) updatePriceStats(ctx context.Context) {
+	if s.history == nil || !s.historyMu.TryLock() {
+		return
+	}
+	defer s.historyMu.Unlock()
+
+	snapshot := s.Latest()
+	if !snapshot.listed {
+		// the cache would forget all watches
+		return
+	}
+	start := time.Now()
+	stats, err := s.fetchPriceStats(ctx, snapshot)
+
+	s.Lock()
+	defer s.Unlock()
+	// a newer snapshot may have been published in the meantime, published snapshots are read-only
+	updated := *s.snapshot
+	updated.PriceStats = make(map[string]*PriceStats, len(stats))
+	for uuid, previous := range s.snapshot.PriceStats {
+		updated.PriceStats[uuid] = previous
+	}
+	for uuid, watchStats := range stats {
+		if _, ok := updated.Prices[uuid]; ok {
+			updated.PriceStats[uuid] = watchStats
+		}
+	}
+	updated.Results = make(map[string]*FetchResult, len(s.snapshot.Results))
+	for name, result := range s.snapshot.Results {
+		updated.Results[name] = result
+	}
+	price := *updated.Results[priceCollectorName]
+	price.Duration += time.Since(start)
+	if price.Err == nil {
+		price.Err = err
+	}
+	updated.Results[priceCollectorName] = &price
+	s.snapshot = &updated
+}
+
+// fetchPriceStats updates the cached price history of every watch with a price and returns its
+// statistics. The cache is only locked while reading or storing a history, not while fetching it.
+func (s *Store) fetchPriceStats(ctx context.Context, snapshot *Snapshot) (map[string]*PriceStats, error) {
+	// restock watches have no price snapshots
+	uuids := make([]string, 0, len(snapshot.Prices))
+	for _, uuid := range snapshot.SortedUUIDs() {
+		if _, ok := snapshot.Prices[uuid]; ok {
+			uuids = append(uuids, uuid)
+		}
+	}
+	s.history.forget(snapshot.Watches)
+
+	var mu sync.Mutex
+	stats := make(map[string]*PriceStats, len(uuids))
+	now := time.Now()
+	err := s.forEachWatch(ctx, uuids, func(uuid string) error {
+		var lastChanged int64
+		if details, ok := snapshot.WatchDetails[uuid]; ok {
+			lastChanged = details.LastChanged
+		}
+
+		history := s.history.get(uuid)
+		err := history.update(ctx, s.ApiClient, uuid, lastChanged)
+		if err != nil {
+			log.Errorf("error while fetching price history of watch %s (%s): %v", uuid, cdio.ErrorReason(err), err)
+		}
+
+		// the statistics cover the snapshots fetched so far, even if the update failed
+		watchStats := history.stats(s.historyWindow, now)
+		s.history.put(uuid, history)
+
+		mu.Lock()
+		defer mu.Unlock()
+		stats[uuid] = watchStats
+		return err
+	})
+	return stats, err
+}
+
+// forEachWatch calls fn for every watch using a bounded pool of workers. A failing watch does not
+// stop the others, the first error returned by fn (or the error of ctx) is returned at the end.
+func (s *Store) forEachWatch(ctx context.Context, uuids []string, fn func(uuid string) error) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	jobs := make(chan string)
+
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uuid := range jobs {
+				if err := fn(uuid); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for _, uuid := range uuids {
+		select {
+		case jobs <- uuid:
+		case <-ctx.Done():
+			log.Errorf("refresh cancelled before fetching all watches: %v", ctx.Err())
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr == nil {
+		return ctx.Err()
+	}
+	return firstErr
+}
+
+// Run refreshes the snapshot immediately and then on every tick of interval until ctx is done. A
+// refresh interrupted by ctx is discarded, so the store keeps serving the last complete snapshot.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	s.refreshInBackground(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.refreshInBackground(ctx)
+		}
+	}
+}
+
+func (s *Store) refreshInBackground(ctx context.Context) {
+	s.refreshMu.Lock()
+	snapshot := s.fetch(ctx)
+	if ctx.Err() != nil {
+		s.refreshMu.Unlock()
+		log.Debug("discarding snapshot of cancelled refresh")
+		return
+	}
+	s.set(snapshot)
+	s.refreshMu.Unlock()
+
+	s.updatePriceStats(ctx)
+}
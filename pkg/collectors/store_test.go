@@ -0,0 +1,392 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package collectors
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/data"
+)
+
+// newTestStore creates a store for the given test server and refreshes it once.
+func newTestStore(server *testutil.ApiTestServer, options ...StoreOption) *Store {
+	store := NewStore(cdio.NewTestApiClient(server.URL()), options...)
+	store.Refresh(context.Background())
+	return store
+}
+
+func TestStore_EmptyBeforeRefresh(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	store := NewStore(cdio.NewTestApiClient(server.URL()))
+	snapshot := store.Snapshot()
+
+	testutil.Equals(t, 0, len(snapshot.Watches))
+	testutil.Assert(t, snapshot.SystemInfo == nil, "expected no system info before first refresh")
+	testutil.Assert(t, snapshot.Timestamp.IsZero(), "expected zero timestamp before first refresh")
+}
+
+func TestStore_Ready(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	store := NewStore(cdio.NewTestApiClient(server.URL()))
+	select {
+	case <-store.Ready():
+		t.Fatal("expected store not to be ready before first refresh")
+	default:
+	}
+
+	store.Refresh(context.Background())
+	select {
+	case <-store.Ready():
+	default:
+		t.Fatal("expected store to be ready after first refresh")
+	}
+}
+
+func TestStore_RunDiscardsCancelledRefresh(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithResponseDelay(50*time.Millisecond))
+	defer server.Close()
+
+	store := NewStore(cdio.NewTestApiClient(server.URL()))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	store.Run(ctx, time.Minute)
+
+	testutil.Assert(t, store.Snapshot().Timestamp.IsZero(), "expected cancelled refresh to be discarded")
+}
+
+func TestStore_Refresh(t *testing.T) {
+	lastId, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	store := newTestStore(server)
+	snapshot := store.Snapshot()
+
+	testutil.Equals(t, 2, len(snapshot.Watches))
+	testutil.Equals(t, 2, len(snapshot.WatchDetails))
+	testutil.Equals(t, 2, len(snapshot.Prices))
+	testutil.Equals(t, float64(200), snapshot.Prices[lastId].Price)
+	testutil.Equals(t, "1.0.0", snapshot.SystemInfo.Version)
+	testutil.Assert(t, snapshot.Up, "expected instance to be up")
+	for name, result := range snapshot.Results {
+		testutil.Assert(t, result.Err == nil, "expected %s to succeed, got %v", name, result.Err)
+	}
+	testutil.Assert(t, !snapshot.Timestamp.IsZero(), "expected snapshot timestamp to be set")
+}
+
+func TestStore_RefreshKeepsPreviousSnapshotUntilDone(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	store := newTestStore(server)
+	previous := store.Snapshot()
+
+	uuid, newItem := testutil.NewTestItem("Item 3", 300, "USD", 0, 0, 0)
+	watchDb[uuid] = newItem
+
+	testutil.Equals(t, 2, len(previous.Watches))
+	store.Refresh(context.Background())
+	testutil.Equals(t, 2, len(previous.Watches))
+	testutil.Equals(t, 3, len(store.Snapshot().Watches))
+}
+
+func TestStore_RefreshBoundsConcurrency(t *testing.T) {
+	watchDb := testutil.NewWatchDb(20)
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithResponseDelay(10*time.Millisecond))
+	defer server.Close()
+
+	store := NewStore(cdio.NewTestApiClient(server.URL()), WithConcurrency(3))
+	store.Refresh(context.Background())
+
+	testutil.Equals(t, 20, len(store.Snapshot().WatchDetails))
+	testutil.Assert(t, server.MaxConcurrentRequests() <= 3, "expected at most 3 concurrent requests, got %d", server.MaxConcurrentRequests())
+	testutil.Assert(t, server.MaxConcurrentRequests() > 1, "expected watches to be fetched concurrently")
+}
+
+func TestStore_RefreshSkipsFailingWatch(t *testing.T) {
+	failingId, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithFailingWatches(failingId))
+	defer server.Close()
+
+	store := newTestStore(server)
+	snapshot := store.Snapshot()
+
+	testutil.Equals(t, 2, len(snapshot.Watches))
+	testutil.Equals(t, 1, len(snapshot.WatchDetails))
+	testutil.Equals(t, 1, len(snapshot.Prices))
+	_, ok := snapshot.WatchDetails[failingId]
+	testutil.Assert(t, !ok, "expected failing watch to be missing from snapshot")
+	testutil.Assert(t, snapshot.Results[watchCollectorName].Err != nil, "expected watch result to contain error")
+	testutil.Assert(t, snapshot.Results[systemCollectorName].Err == nil, "expected system result to succeed")
+}
+
+func TestStore_RefreshSkipsPausedWatches(t *testing.T) {
+	watchDb := testutil.NewWatchDb(0)
+	uuid, watch := testutil.NewTestItem("Item 1", 90, "USD", 20, 15, 10)
+	watchDb[uuid] = watch
+	pausedId, pausedWatch := testutil.NewTestItem("Item 1", 80, "USD", 20, 15, 10)
+	pausedWatch.Paused = true
+	watchDb[pausedId] = pausedWatch
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	testutil.Equals(t, 2, len(newTestStore(server).Snapshot().Duplicates))
+
+	snapshot := newTestStore(server, WithSkipPaused(), WithPriceHistory(NewPriceHistoryCache(), 7*24*time.Hour)).Snapshot()
+
+	testutil.Equals(t, 1, len(snapshot.Watches))
+	testutil.Equals(t, 0, len(snapshot.Duplicates))
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/watch/"+pausedId))
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/watch/"+pausedId+"/history/latest"))
+	testutil.Equals(t, 0, server.RequestCount("/api/v1/watch/"+pausedId+"/history"))
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/watch/"+uuid+"/history"))
+}
+
+func TestStore_RefreshRestockWatch(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	restockId, restockWatch := testutil.NewTestRestockItem("Restock Item", 19.9, "CHF", true)
+	watchDb[restockId] = restockWatch
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	snapshot := newTestStore(server).Snapshot()
+
+	testutil.Equals(t, 2, len(snapshot.Prices))
+	testutil.Equals(t, restockWatch.Restock, snapshot.Restocks[restockId])
+	testutil.Equals(t, data.ProcessorRestockDiff, snapshot.Processor(restockId))
+	// restock watches have no offer snapshot to fetch
+	testutil.Equals(t, 0, server.RequestCount("/api/v1/watch/"+restockId+"/history/latest"))
+	testutil.Assert(t, snapshot.Results[priceCollectorName].Err == nil, "expected price data to succeed, got %v", snapshot.Results[priceCollectorName].Err)
+}
+
+func TestStore_RefreshPriceHistory(t *testing.T) {
+	now := time.Now()
+	watchDb := testutil.NewWatchDb(0)
+	uuid, watch := testutil.NewTestItem("Item 1", 90, "USD", 20, 15, 10)
+	watchDb[uuid] = watch
+	restockId, restockWatch := testutil.NewTestRestockItem("Restock Item", 19.9, "CHF", true)
+	watchDb[restockId] = restockWatch
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithHistory(uuid, map[int64]*data.PriceData{
+		now.Add(-10 * 24 * time.Hour).Unix(): {Price: 100, Currency: "USD"},
+		now.Add(-1 * 24 * time.Hour).Unix():  {Price: 90, Currency: "USD"},
+	}))
+	defer server.Close()
+
+	store := newTestStore(server, WithPriceHistory(NewPriceHistoryCache(), 7*24*time.Hour))
+	snapshot := store.Snapshot()
+
+	testutil.Equals(t, 1, len(snapshot.PriceStats))
+	testutil.Equals(t, PriceAggregate{Min: 90, Max: 100, Mean: 95, Count: 2, Changes: 1}, snapshot.PriceStats[uuid].All)
+	testutil.Equals(t, PriceAggregate{Min: 90, Max: 90, Mean: 90, Count: 1, Changes: 1}, snapshot.PriceStats[uuid].Window)
+	// restock watches have no price history
+	testutil.Equals(t, 0, server.RequestCount("/api/v1/watch/"+restockId+"/history"))
+
+	// removed watches are forgotten
+	delete(watchDb, uuid)
+	store.Refresh(context.Background())
+	testutil.Equals(t, 0, len(store.history.watches))
+}
+
+func TestStore_RefreshKeepsPriceStatsUntilUpdated(t *testing.T) {
+	now := time.Now()
+	watchDb := testutil.NewWatchDb(0)
+	uuid, watch := testutil.NewTestItem("Item 1", 90, "USD", 20, 15, 10)
+	watchDb[uuid] = watch
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithHistory(uuid, map[int64]*data.PriceData{
+		now.Add(-1 * 24 * time.Hour).Unix(): {Price: 90, Currency: "USD"},
+	}))
+	defer server.Close()
+
+	store := newTestStore(server, WithPriceHistory(NewPriceHistoryCache(), 7*24*time.Hour))
+	stats := store.Snapshot().PriceStats[uuid]
+	testutil.Assert(t, stats != nil, "expected price statistics after refresh")
+
+	// a new snapshot is published with the previous statistics before the history is updated
+	store.set(store.fetch(context.Background()))
+	testutil.Equals(t, stats, store.Snapshot().PriceStats[uuid])
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/watch/"+uuid+"/history"))
+}
+
+func TestStore_RefreshIfStaleUpdatesPriceStatsInBackground(t *testing.T) {
+	now := time.Now()
+	watchDb := testutil.NewWatchDb(0)
+	uuid, watch := testutil.NewTestItem("Item 1", 90, "USD", 20, 15, 10)
+	watchDb[uuid] = watch
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithHistory(uuid, map[int64]*data.PriceData{
+		now.Add(-1 * 24 * time.Hour).Unix(): {Price: 90, Currency: "USD"},
+	}))
+	defer server.Close()
+
+	store := NewStore(cdio.NewTestApiClient(server.URL()), WithMaxAge(time.Minute), WithPriceHistory(NewPriceHistoryCache(), 7*24*time.Hour))
+	testutil.Equals(t, 1, len(store.Snapshot().Prices))
+
+	// the statistics of a scrape triggered refresh are published once the history is fetched
+	for i := 0; i < 100 && store.Latest().PriceStats[uuid] == nil; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	testutil.Assert(t, store.Latest().PriceStats[uuid] != nil, "expected price statistics to be published")
+	testutil.Equals(t, 1, store.Latest().PriceStats[uuid].All.Count)
+}
+
+func TestStore_RefreshWithoutPriceHistory(t *testing.T) {
+	lastId, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	snapshot := newTestStore(server).Snapshot()
+
+	testutil.Equals(t, 0, len(snapshot.PriceStats))
+	testutil.Equals(t, 0, server.RequestCount("/api/v1/watch/"+lastId+"/history"))
+}
+
+func TestStore_RefreshWithoutTagSupport(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithFaults(testutil.Fault{
+		Path:   "/api/v1/tags",
+		Status: http.StatusNotFound,
+		Count:  1,
+	}))
+	defer server.Close()
+
+	snapshot := newTestStore(server).Snapshot()
+
+	testutil.Equals(t, 0, len(snapshot.Tags))
+	testutil.Assert(t, snapshot.Results[watchCollectorName].Err == nil, "expected missing tags endpoint to be ignored, got %v", snapshot.Results[watchCollectorName].Err)
+}
+
+func TestStore_RefreshFailingTags(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithFaults(testutil.Fault{
+		Path:   "/api/v1/tags",
+		Status: http.StatusInternalServerError,
+		Count:  1,
+	}))
+	defer server.Close()
+
+	snapshot := newTestStore(server).Snapshot()
+
+	testutil.Equals(t, 2, len(snapshot.WatchDetails))
+	testutil.Assert(t, errors.Is(snapshot.Results[watchCollectorName].Err, cdio.ErrServerError), "expected watch result to contain tag error, got %v", snapshot.Results[watchCollectorName].Err)
+}
+
+func TestStore_MaxAgeSharesRequestsAcrossCollectors(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	store := NewStore(cdio.NewTestApiClient(server.URL()), WithMaxAge(time.Minute))
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(
+		NewSystemCollector(store),
+		NewWatchCollector(store),
+		NewPriceCollector(store),
+		NewRefreshCollector(store),
+	)
+
+	_, err := registry.Gather()
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/watch"))
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/systeminfo"))
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/tags"))
+	for uuid := range watchDb {
+		testutil.Equals(t, 1, server.RequestCount("/api/v1/watch/"+uuid))
+		testutil.Equals(t, 1, server.RequestCount("/api/v1/watch/"+uuid+"/history/latest"))
+	}
+	testutil.Equals(t, 7, server.TotalRequestCount())
+
+	// a second scrape within max age must not hit the api again
+	_, err = registry.Gather()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 7, server.TotalRequestCount())
+}
+
+func TestStore_MaxAgeRefreshesStaleSnapshot(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	store := NewStore(cdio.NewTestApiClient(server.URL()), WithMaxAge(10*time.Millisecond))
+	store.Snapshot()
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/watch"))
+
+	time.Sleep(20 * time.Millisecond)
+	store.Snapshot()
+	testutil.Equals(t, 2, server.RequestCount("/api/v1/watch"))
+}
+
+func TestStore_WithoutMaxAgeNeverRefreshesOnRead(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	store := NewStore(cdio.NewTestApiClient(server.URL()))
+	store.Snapshot()
+	testutil.Equals(t, 0, server.TotalRequestCount())
+}
+
+func TestSnapshot_SortedUUIDs(t *testing.T) {
+	snapshot := newSnapshot()
+	for _, uuid := range []string{"c", "a", "b"} {
+		snapshot.Watches[uuid] = nil
+	}
+	testutil.Equals(t, []string{"a", "b", "c"}, snapshot.SortedUUIDs())
+}
+
+func TestSnapshot_TagNames(t *testing.T) {
+	snapshot := newSnapshot()
+	snapshot.Tags["t1"] = &data.Tag{Uuid: "t1", Title: "b"}
+	snapshot.Tags["t2"] = &data.Tag{Uuid: "t2", Title: "a"}
+	snapshot.Watches["w1"] = &data.WatchItem{Tags: []string{"t1", "t2", "unknown"}}
+
+	testutil.Equals(t, []string{"a", "b"}, snapshot.TagNames("w1"))
+	testutil.Equals(t, []string(nil), snapshot.TagNames("missing"))
+}
+
+func TestStore_Run(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	store := NewStore(cdio.NewTestApiClient(server.URL()))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		store.Run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	first := waitForRefresh(t, store, time.Time{})
+	waitForRefresh(t, store, first)
+
+	cancel()
+	<-done
+}
+
+// waitForRefresh blocks until the store holds a snapshot newer than since and returns its timestamp.
+func waitForRefresh(t *testing.T, store *Store, since time.Time) time.Time {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if ts := store.Snapshot().Timestamp; ts.After(since) {
+			return ts
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("store did not refresh within deadline")
+	return time.Time{}
+}
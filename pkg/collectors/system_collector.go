@@ -4,8 +4,6 @@ package collectors
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
-	log "github.com/sirupsen/logrus"
 )
 
 type systemCollector struct {
@@ -17,9 +15,9 @@ type systemCollector struct {
 	watchCount   *prometheus.Desc
 }
 
-func NewSystemCollector(client *cdio.ApiClient) *systemCollector {
+func NewSystemCollector(store *Store) *systemCollector {
 	return &systemCollector{
-		baseCollector: *newBaseCollector(client),
+		baseCollector: *newBaseCollector(store),
 		queueSize: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "system", "queue_size"),
 			"Current changedetection.io instance queue size",
@@ -54,11 +52,7 @@ func (c *systemCollector) Collect(ch chan<- prometheus.Metric) {
 	c.RLock()
 	defer c.RUnlock()
 
-	// check for new watches before collecting metrics
-	system, err := c.ApiClient.GetSystemInfo()
-	if err != nil {
-		log.Errorf("error while fetching system info: %v", err)
-	} else {
+	if system := c.Store.Snapshot().SystemInfo; system != nil {
 		ch <- prometheus.MustNewConstMetric(c.queueSize, prometheus.GaugeValue, float64(system.QueueSize), system.Version)
 		ch <- prometheus.MustNewConstMetric(c.watchCount, prometheus.GaugeValue, float64(system.WatchCount), system.Version)
 		ch <- prometheus.MustNewConstMetric(c.overdueCount, prometheus.GaugeValue, float64(len(system.OverdueWatches)), system.Version)
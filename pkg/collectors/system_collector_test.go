@@ -6,7 +6,6 @@ import (
 	"testing"
 
 	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
-	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
 	"github.com/schaermu/changedetection.io-exporter/pkg/data"
 )
 
@@ -30,8 +29,8 @@ func TestSystemCollector(t *testing.T) {
 	}))
 	defer server.Close()
 
-	client := cdio.NewTestApiClient(server.URL())
-	c := NewSystemCollector(client)
+	store := newTestStore(server)
+	c := NewSystemCollector(store)
 
 	testutil.ExpectMetricCount(t, c, 1, expectedSystemMetrics...)
 	testutil.ExpectMetrics(t, c, "system_metrics.prom", expectedSystemMetrics...)
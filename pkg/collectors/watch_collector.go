@@ -3,8 +3,10 @@
 package collectors
 
 import (
+	"strconv"
+
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/data"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -15,11 +17,21 @@ type watchCollector struct {
 	fetchTime              *prometheus.Desc
 	notificationAlertCount *prometheus.Desc
 	lastCheckStatus        *prometheus.Desc
+	lastChecked            *prometheus.Desc
+	lastChanged            *prometheus.Desc
+	lastError              *prometheus.Desc
+	lastErrorInfo          *prometheus.Desc
+	paused                 *prometheus.Desc
+	notificationsMuted     *prometheus.Desc
+	tagInfo                *prometheus.Desc
+	watchInfo              *prometheus.Desc
 }
 
-func NewWatchCollector(client *cdio.ApiClient) *watchCollector {
+func NewWatchCollector(store *Store, options ...CollectorOption) *watchCollector {
+	opts := newCollectorOptions(options...)
+	labels := opts.watchLabelNames()
 	return &watchCollector{
-		baseCollector: *newBaseCollector(client),
+		baseCollector: baseCollector{collectorOptions: opts, Store: store},
 		checkCount: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "watch", "check_count"),
 			"Number of checks for a watch",
@@ -40,6 +52,46 @@ func NewWatchCollector(client *cdio.ApiClient) *watchCollector {
 			"Status of the last check for a watch",
 			labels, nil,
 		),
+		lastChecked: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "last_checked_timestamp_seconds"),
+			"Unix timestamp of the last check of a watch, only exposed once it has been checked",
+			labels, nil,
+		),
+		lastChanged: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "last_changed_timestamp_seconds"),
+			"Unix timestamp of the last detected change of a watch, only exposed once it has changed",
+			labels, nil,
+		),
+		lastError: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "last_error"),
+			"Whether the last check of a watch failed",
+			labels, nil,
+		),
+		lastErrorInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "last_error_info"),
+			"Error message of the last check of a failing watch, with a constant '1' value",
+			append(opts.identityLabelNames(), "message"), nil,
+		),
+		paused: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "paused"),
+			"Whether a watch is paused",
+			labels, nil,
+		),
+		notificationsMuted: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "notifications_muted"),
+			"Whether the notifications of a watch are muted",
+			labels, nil,
+		),
+		tagInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "tag_info"),
+			"Tags assigned to a watch, one series with a constant '1' value per tag",
+			append(opts.identityLabelNames(), "tag"), nil,
+		),
+		watchInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "watch", "info"),
+			"Metadata of a watch, with a constant '1' value",
+			[]string{"title", "source", "uuid", "url", "processor", "fetch_backend", "paused", "muted"}, nil,
+		),
 	}
 }
 
@@ -48,32 +100,58 @@ func (c *watchCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.fetchTime
 	ch <- c.notificationAlertCount
 	ch <- c.lastCheckStatus
+	ch <- c.lastChecked
+	ch <- c.lastChanged
+	ch <- c.lastError
+	ch <- c.lastErrorInfo
+	ch <- c.paused
+	ch <- c.notificationsMuted
+	ch <- c.tagInfo
+	ch <- c.watchInfo
 }
 
 func (c *watchCollector) Collect(ch chan<- prometheus.Metric) {
 	c.RLock()
 	defer c.RUnlock()
 
-	// check for new watches before collecting metrics
-	watches, err := c.ApiClient.GetWatches()
-	if err != nil {
-		log.Errorf("error while fetching watches: %v", err)
-	}
+	snapshot := c.Store.Snapshot()
+	for _, uuid := range snapshot.SortedUUIDs() {
+		watchData, ok := snapshot.WatchDetails[uuid]
+		if !ok {
+			continue
+		}
 
-	for uuid := range watches {
-		// get latest watch data
-		if watchData, err := c.ApiClient.GetWatchData(uuid); err == nil {
-			if metricLabels, err := watchData.GetMetrics(); err != nil {
-				log.Error(err)
-				continue
-			} else {
-				ch <- prometheus.MustNewConstMetric(c.checkCount, prometheus.CounterValue, float64(watchData.CheckCount), metricLabels...)
-				ch <- prometheus.MustNewConstMetric(c.fetchTime, prometheus.GaugeValue, watchData.FetchTime, metricLabels...)
-				ch <- prometheus.MustNewConstMetric(c.notificationAlertCount, prometheus.CounterValue, float64(watchData.NotificationAlertCount), metricLabels...)
-				ch <- prometheus.MustNewConstMetric(c.lastCheckStatus, prometheus.GaugeValue, float64(watchData.LastCheckStatus), metricLabels...)
-			}
-		} else {
+		if metricLabels, err := c.watchLabels(snapshot, uuid, watchData); err != nil {
 			log.Error(err)
+			continue
+		} else {
+			ch <- prometheus.MustNewConstMetric(c.checkCount, prometheus.CounterValue, float64(watchData.CheckCount), metricLabels...)
+			ch <- prometheus.MustNewConstMetric(c.fetchTime, prometheus.GaugeValue, watchData.FetchTime, metricLabels...)
+			ch <- prometheus.MustNewConstMetric(c.notificationAlertCount, prometheus.CounterValue, float64(watchData.NotificationAlertCount), metricLabels...)
+			ch <- prometheus.MustNewConstMetric(c.lastCheckStatus, prometheus.GaugeValue, float64(watchData.LastCheckStatus), metricLabels...)
+			if watchData.LastChecked > 0 {
+				ch <- prometheus.MustNewConstMetric(c.lastChecked, prometheus.GaugeValue, float64(watchData.LastChecked), metricLabels...)
+			}
+			if watchData.LastChanged > 0 {
+				ch <- prometheus.MustNewConstMetric(c.lastChanged, prometheus.GaugeValue, float64(watchData.LastChanged), metricLabels...)
+			}
+			ch <- prometheus.MustNewConstMetric(c.lastError, prometheus.GaugeValue, boolToFloat(watchData.LastError.Failed()), metricLabels...)
+			ch <- prometheus.MustNewConstMetric(c.paused, prometheus.GaugeValue, boolToFloat(watchData.Paused), metricLabels...)
+			ch <- prometheus.MustNewConstMetric(c.notificationsMuted, prometheus.GaugeValue, boolToFloat(watchData.NotificationMuted), metricLabels...)
+
+			identity := metricLabels[:len(c.identityLabelNames())]
+			if watchData.LastError.Failed() {
+				errorLabels := append(append([]string{}, identity...), sanitizeMessage(watchData.LastError.Message))
+				ch <- prometheus.MustNewConstMetric(c.lastErrorInfo, prometheus.GaugeValue, 1, errorLabels...)
+			}
+			for _, tag := range snapshot.TagNames(uuid) {
+				tagLabels := append(append([]string{}, identity...), tag)
+				ch <- prometheus.MustNewConstMetric(c.tagInfo, prometheus.GaugeValue, 1, tagLabels...)
+			}
+			ch <- prometheus.MustNewConstMetric(c.watchInfo, prometheus.GaugeValue, 1,
+				identity[0], identity[1], uuid, data.RedactUrl(watchData.Url), watchData.Processor, watchData.FetchBackend,
+				strconv.FormatBool(watchData.Paused), strconv.FormatBool(watchData.NotificationMuted),
+			)
 		}
 	}
 }
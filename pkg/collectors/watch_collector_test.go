@@ -3,10 +3,16 @@
 package collectors
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
 	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
-	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/data"
 )
 
 var (
@@ -23,8 +29,8 @@ func TestWatchCollector(t *testing.T) {
 	server := testutil.CreateTestApiServer(t, watchDb)
 	defer server.Close()
 
-	client := cdio.NewTestApiClient(server.URL())
-	c := NewWatchCollector(client)
+	store := newTestStore(server)
+	c := NewWatchCollector(store)
 
 	//testutil.ExpectMetricCount(t, c, 2, expectedWatchMetrics...)
 	testutil.ExpectMetrics(t, c, "watch_metrics.prom", expectedWatchMetrics...)
@@ -37,8 +43,206 @@ func TestWatchCollector_IgnoresWatchesWithoutTitle(t *testing.T) {
 	watchDb[emptyUuid] = emptyTitleItem
 	defer server.Close()
 
-	client := cdio.NewTestApiClient(server.URL())
-	c := NewWatchCollector(client)
+	store := newTestStore(server)
+	c := NewWatchCollector(store)
 
 	testutil.ExpectMetrics(t, c, "watch_metrics.prom", expectedWatchMetrics...)
 }
+
+// newTaggedTestServer returns a test server whose first item is tagged with a vendor and a
+// priority, the second one only with a priority.
+func newTaggedTestServer(t *testing.T) *testutil.ApiTestServer {
+	uuid2, watchDb := testutil.NewCollectorTestDb()
+	vendor := testutil.NewTestTag("vendor: acme")
+	high := testutil.NewTestTag("priority: high")
+	low := testutil.NewTestTag("priority: low")
+	for uuid, watch := range watchDb {
+		if uuid == uuid2 {
+			// unknown tag ids are ignored
+			watch.Tags = []string{low.Uuid, "a8b5f1c2-0000-0000-0000-000000000000"}
+		} else {
+			watch.Tags = []string{vendor.Uuid, high.Uuid}
+		}
+	}
+	return testutil.CreateTestApiServer(t, watchDb, testutil.WithTags(vendor, high, low))
+}
+
+func TestWatchCollector_TagInfo(t *testing.T) {
+	server := newTaggedTestServer(t)
+	defer server.Close()
+
+	c := NewWatchCollector(newTestStore(server))
+
+	testutil.ExpectMetricCount(t, c, 2, expectedWatchMetrics...)
+	testutil.ExpectMetricCount(t, c, 3, "changedetectionio_watch_tag_info")
+}
+
+func TestWatchCollector_TagLabel(t *testing.T) {
+	server := newTaggedTestServer(t)
+	defer server.Close()
+
+	c := NewWatchCollector(newTestStore(server), WithTagLabel(regexp.MustCompile("^vendor:")))
+
+	testutil.ExpectMetrics(t, c, "watch_tag_metrics.prom", "changedetectionio_watch_check_count", "changedetectionio_watch_tag_info")
+}
+
+// newDuplicateTestServer returns a test server with two identical watches and a third one.
+func newDuplicateTestServer(t *testing.T) *testutil.ApiTestServer {
+	watchDb := testutil.NewWatchDb(0)
+	_, watchDb["11111111-1111-1111-1111-111111111111"] = testutil.NewTestItem("Item 1", 100, "USD", 20, 15, 10)
+	_, watchDb["22222222-2222-2222-2222-222222222222"] = testutil.NewTestItem("Item 1", 100, "USD", 20, 15, 10)
+	_, watchDb["33333333-3333-3333-3333-333333333333"] = testutil.NewTestItem("Item 2", 200, "USD", 20, 15, 10)
+	return testutil.CreateTestApiServer(t, watchDb)
+}
+
+func TestWatchCollector_DisambiguatesDuplicates(t *testing.T) {
+	server := newDuplicateTestServer(t)
+	defer server.Close()
+
+	store := newTestStore(server)
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(NewWatchCollector(store), NewPriceCollector(store))
+
+	_, err := registry.Gather()
+	testutil.Ok(t, err)
+	testutil.ExpectMetrics(t, NewWatchCollector(store), "watch_duplicate_metrics.prom", "changedetectionio_watch_check_count")
+}
+
+func TestWatchCollector_UuidLabel(t *testing.T) {
+	server := newDuplicateTestServer(t)
+	defer server.Close()
+
+	store := newTestStore(server)
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(NewWatchCollector(store, WithUuidLabel()), NewPriceCollector(store, WithUuidLabel()))
+
+	_, err := registry.Gather()
+	testutil.Ok(t, err)
+	testutil.ExpectMetrics(t, NewWatchCollector(store, WithUuidLabel()), "watch_uuid_metrics.prom", "changedetectionio_watch_check_count")
+}
+
+func TestWatchCollector_WatchInfo(t *testing.T) {
+	watchDb := testutil.NewWatchDb(0)
+	_, watchDb["11111111-1111-1111-1111-111111111111"] = testutil.NewTestItem("Item 1", 100, "USD", 20, 15, 10)
+	_, watch := testutil.NewTestItem("Item 2", 200, "USD", 20, 15, 10)
+	watch.Url = "https://user:pw@www.item-2.org/path?token=x"
+	watch.Processor = "restock_diff"
+	watch.FetchBackend = "html_webdriver"
+	watch.Paused = true
+	watch.NotificationMuted = true
+	watchDb["22222222-2222-2222-2222-222222222222"] = watch
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	c := NewWatchCollector(newTestStore(server))
+
+	testutil.ExpectMetrics(t, c, "watch_info_metrics.prom", "changedetectionio_watch_info")
+}
+
+func TestWatchCollector_Timestamps(t *testing.T) {
+	watchDb := testutil.NewWatchDb(0)
+	uuid1, watch1 := testutil.NewTestItem("Item 1", 100, "USD", 20, 15, 10)
+	watch1.LastChecked = 1714600000
+	watch1.LastChanged = 1714500000
+	watchDb[uuid1] = watch1
+	// a watch that has never changed only exposes its last check
+	uuid2, watch2 := testutil.NewTestItem("Item 2", 200, "USD", 20, 15, 10)
+	watch2.LastChecked = 1714700000
+	watchDb[uuid2] = watch2
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	c := NewWatchCollector(newTestStore(server))
+
+	testutil.ExpectMetrics(t, c, "watch_timestamp_metrics.prom",
+		"changedetectionio_watch_last_checked_timestamp_seconds",
+		"changedetectionio_watch_last_changed_timestamp_seconds",
+	)
+}
+
+func TestWatchCollector_LastError(t *testing.T) {
+	watchDb := testutil.NewWatchDb(0)
+	uuid1, watch1 := testutil.NewTestItem("Item 1", 100, "USD", 20, 15, 10)
+	watch1.LastError = data.LastError{Message: "Received  status code 403\n\tfrom server"}
+	watchDb[uuid1] = watch1
+	uuid2, watch2 := testutil.NewTestItem("Item 2", 200, "USD", 20, 15, 10)
+	watchDb[uuid2] = watch2
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	c := NewWatchCollector(newTestStore(server))
+
+	testutil.ExpectMetrics(t, c, "watch_last_error_metrics.prom",
+		"changedetectionio_watch_last_error",
+		"changedetectionio_watch_last_error_info",
+	)
+}
+
+func TestSanitizeMessage(t *testing.T) {
+	testutil.Equals(t, "", sanitizeMessage(""))
+	testutil.Equals(t, "a b c", sanitizeMessage("  a\n\tb\r\nc "))
+	testutil.Equals(t, "invalid", sanitizeMessage("inv\xffalid\x00"))
+
+	long := sanitizeMessage(strings.Repeat("ä", maxMessageLength+10))
+	testutil.Equals(t, strings.Repeat("ä", maxMessageLength)+"...", long)
+}
+
+func TestWatchCollector_PausedAndMuted(t *testing.T) {
+	watchDb := testutil.NewWatchDb(0)
+	uuid1, watch1 := testutil.NewTestItem("Item 1", 100, "USD", 20, 15, 10)
+	watch1.NotificationMuted = true
+	watchDb[uuid1] = watch1
+	uuid2, watch2 := testutil.NewTestItem("Item 2", 200, "USD", 20, 15, 10)
+	watch2.Paused = true
+	watchDb[uuid2] = watch2
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	store := newTestStore(server)
+
+	testutil.ExpectMetrics(t, NewWatchCollector(store), "watch_paused_metrics.prom",
+		"changedetectionio_watch_paused",
+		"changedetectionio_watch_notifications_muted",
+	)
+
+	// paused watches are omitted entirely
+	c := NewWatchCollector(newTestStore(server, WithSkipPaused()))
+	testutil.ExpectMetricCount(t, c, 1, expectedWatchMetrics...)
+	testutil.ExpectMetrics(t, c, "watch_paused_metrics_skipped.prom",
+		"changedetectionio_watch_paused",
+		"changedetectionio_watch_notifications_muted",
+	)
+}
+
+func TestWatchCollector_DeterministicOrder(t *testing.T) {
+	watchDb := testutil.NewWatchDb(10)
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	c := NewWatchCollector(newTestStore(server))
+
+	first := collectLabelPairs(c)
+	testutil.Equals(t, 80, len(first))
+	for i := 0; i < 5; i++ {
+		testutil.Equals(t, first, collectLabelPairs(c))
+	}
+}
+
+// collectLabelPairs collects all metrics of c and returns their label pairs in emitted order.
+func collectLabelPairs(c prometheus.Collector) []string {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	var ret []string
+	for m := range ch {
+		metric := &dto.Metric{}
+		if err := m.Write(metric); err != nil {
+			panic(err)
+		}
+		ret = append(ret, fmt.Sprintf("%v", metric.GetLabel()))
+	}
+	return ret
+}
@@ -0,0 +1,412 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultModule is the name of the probe module using the api key of the main instance.
+const DefaultModule = "default"
+
+// currencyCode matches ISO 4217 currency codes (i.e. USD).
+var currencyCode = regexp.MustCompile(`^[A-Za-z]{3}$`)
+
+// envReference matches references to environment variables in the form of ${VAR}, including an
+// optional second dollar sign which escapes the reference.
+var envReference = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Config contains all settings that can be reloaded at runtime.
+type Config struct {
+	Changedetection ChangedetectionConfig   `yaml:"changedetection"`
+	Refresh         RefreshConfig           `yaml:"refresh"`
+	Retry           RetryConfig             `yaml:"retry"`
+	Readiness       ReadinessConfig         `yaml:"readiness"`
+	Watches         WatchesConfig           `yaml:"watches"`
+	Prices          PricesConfig            `yaml:"prices"`
+	Modules         map[string]ModuleConfig `yaml:"modules"`
+}
+
+type ChangedetectionConfig struct {
+	Url        string `yaml:"url"`
+	ApiKey     string `yaml:"api_key"`
+	ApiKeyFile string `yaml:"api_key_file"`
+}
+
+type RefreshConfig struct {
+	Interval    time.Duration `yaml:"interval"`
+	CacheTTL    time.Duration `yaml:"cache_ttl"`
+	Concurrency int           `yaml:"concurrency"`
+}
+
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	Jitter         float64       `yaml:"jitter"`
+}
+
+type ReadinessConfig struct {
+	Window  time.Duration `yaml:"window"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type WatchesConfig struct {
+	// TagLabel is a regular expression selecting the tag added as label to all watch metrics,
+	// the label is omitted if empty.
+	TagLabel string `yaml:"tag_label"`
+	// UuidLabel adds the id of the watch as label to all watch metrics.
+	UuidLabel bool `yaml:"uuid_label"`
+	// SkipPaused omits paused watches from all watch and price metrics, without fetching their data.
+	SkipPaused bool `yaml:"skip_paused"`
+}
+
+type PricesConfig struct {
+	// BaseCurrency is the currency all prices are additionally converted to, using the exchange
+	// rates of RatesFile.
+	BaseCurrency string `yaml:"base_currency"`
+	// RatesFile is the path to a file containing exchange rates in the ECB xml or the yaml format.
+	RatesFile string `yaml:"rates_file"`
+	// History enables statistics of the prices of all snapshots of every watch.
+	History bool `yaml:"history"`
+	// HistoryWindow is the duration of the rolling window of the price statistics.
+	HistoryWindow time.Duration `yaml:"history_window"`
+}
+
+type ModuleConfig struct {
+	ApiKey string `yaml:"api_key"`
+	// Targets are the base urls of the instances the api key may be sent to by the probe.
+	Targets []string `yaml:"targets"`
+}
+
+// AllowsTarget reports whether the api key of the module may be sent to target.
+func (m ModuleConfig) AllowsTarget(target string) bool {
+	target = NormalizeTarget(target)
+	for _, allowed := range m.Targets {
+		if NormalizeTarget(allowed) == target {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeTarget returns the base url of an instance in a comparable form, with a lowercase
+// scheme and host and without trailing slash. Invalid urls are returned unchanged.
+func NormalizeTarget(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+// Default returns a configuration containing the default value of every setting.
+func Default() *Config {
+	return &Config{
+		Refresh: RefreshConfig{
+			Interval:    30 * time.Second,
+			CacheTTL:    5 * time.Second,
+			Concurrency: 4,
+		},
+		Retry: RetryConfig{
+			MaxAttempts:    3,
+			InitialBackoff: 500 * time.Millisecond,
+			MaxBackoff:     10 * time.Second,
+			Jitter:         0.2,
+		},
+		Readiness: ReadinessConfig{
+			Window:  time.Minute,
+			Timeout: 5 * time.Second,
+		},
+		Prices: PricesConfig{
+			HistoryWindow: 7 * 24 * time.Hour,
+		},
+		Modules: make(map[string]ModuleConfig),
+	}
+}
+
+// Overrides contains settings passed on the command line, which take precedence over both the
+// environment variables and the configuration file.
+type Overrides struct {
+	Url        string
+	ApiKeyFile string
+}
+
+// Load reads the configuration file at path (if any), overrides its settings with the environment
+// variables that are set and the given overrides, and validates the result.
+func Load(path string, overrides Overrides) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := cfg.parse(content); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(os.Environ()); err != nil {
+		return nil, err
+	}
+	cfg.applyOverrides(overrides)
+	if err := cfg.readApiKeyFile(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parse decodes a yaml document into the configuration. References to environment variables in
+// the form of ${VAR} are expanded before decoding, any other dollar sign is kept as is. Unknown
+// fields are rejected.
+func (c *Config) parse(content []byte) error {
+	expanded := envReference.ReplaceAllStringFunc(string(content), func(reference string) string {
+		if strings.HasPrefix(reference, "$$") {
+			// allows to escape a literal reference as $${VAR}
+			return reference[1:]
+		}
+		return os.Getenv(envReference.FindStringSubmatch(reference)[1])
+	})
+
+	decoder := yaml.NewDecoder(bytes.NewBufferString(expanded))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil {
+		return err
+	}
+	if c.Changedetection.ApiKey != "" && c.Changedetection.ApiKeyFile != "" {
+		return fmt.Errorf("only one of changedetection.api_key and changedetection.api_key_file may be set")
+	}
+	if c.Modules == nil {
+		c.Modules = make(map[string]ModuleConfig)
+	}
+	return nil
+}
+
+// applyEnv overrides settings with the given environment variables (in the form of key=value).
+func (c *Config) applyEnv(environ []string) error {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		env[name] = value
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	var errs []error
+	str := func(name string, target *string) {
+		if value, ok := lookup(name); ok && value != "" {
+			*target = value
+		}
+	}
+	duration := func(name string, target *time.Duration) {
+		if value, ok := lookup(name); ok && value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a duration (i.e. 30s), got %q", name, value))
+			}
+			*target = d
+		}
+	}
+	number := func(name string, target *int) {
+		if value, ok := lookup(name); ok && value != "" {
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a number, got %q", name, value))
+			}
+			*target = i
+		}
+	}
+	boolean := func(name string, target *bool) {
+		if value, ok := lookup(name); ok && value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a boolean, got %q", name, value))
+			}
+			*target = b
+		}
+	}
+	fraction := func(name string, target *float64) {
+		if value, ok := lookup(name); ok && value != "" {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a number, got %q", name, value))
+			}
+			*target = f
+		}
+	}
+
+	str("CDIO_API_BASE_URL", &c.Changedetection.Url)
+	if value := env["CDIO_API_KEY"]; value != "" {
+		c.Changedetection.ApiKey = value
+		c.Changedetection.ApiKeyFile = ""
+	}
+	if value := env["CDIO_API_KEY_FILE"]; value != "" {
+		c.Changedetection.ApiKey = ""
+		c.Changedetection.ApiKeyFile = value
+	}
+	duration("REFRESH_INTERVAL", &c.Refresh.Interval)
+	duration("CACHE_TTL", &c.Refresh.CacheTTL)
+	number("FETCH_CONCURRENCY", &c.Refresh.Concurrency)
+	number("RETRY_MAX_ATTEMPTS", &c.Retry.MaxAttempts)
+	duration("RETRY_INITIAL_BACKOFF", &c.Retry.InitialBackoff)
+	duration("RETRY_MAX_BACKOFF", &c.Retry.MaxBackoff)
+	fraction("RETRY_JITTER", &c.Retry.Jitter)
+	duration("READINESS_WINDOW", &c.Readiness.Window)
+	duration("READINESS_TIMEOUT", &c.Readiness.Timeout)
+	str("WATCH_TAG_LABEL", &c.Watches.TagLabel)
+	boolean("WATCH_UUID_LABEL", &c.Watches.UuidLabel)
+	boolean("WATCH_SKIP_PAUSED", &c.Watches.SkipPaused)
+	str("PRICE_BASE_CURRENCY", &c.Prices.BaseCurrency)
+	str("PRICE_RATES_FILE", &c.Prices.RatesFile)
+	boolean("PRICE_HISTORY", &c.Prices.History)
+	duration("PRICE_HISTORY_WINDOW", &c.Prices.HistoryWindow)
+
+	// every CDIO_API_KEY_<MODULE> variable defines an additional probe module, which may only be
+	// used for the comma separated urls of CDIO_TARGETS_<MODULE>
+	for name, value := range env {
+		if name == "CDIO_API_KEY_FILE" || value == "" {
+			continue
+		}
+		if module, ok := strings.CutPrefix(name, "CDIO_API_KEY_"); ok && module != "" {
+			m := c.Modules[strings.ToLower(module)]
+			m.ApiKey = value
+			c.Modules[strings.ToLower(module)] = m
+		}
+		if module, ok := strings.CutPrefix(name, "CDIO_TARGETS_"); ok && module != "" {
+			m := c.Modules[strings.ToLower(module)]
+			m.Targets = nil
+			for _, target := range strings.Split(value, ",") {
+				if target = strings.TrimSpace(target); target != "" {
+					m.Targets = append(m.Targets, target)
+				}
+			}
+			c.Modules[strings.ToLower(module)] = m
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Config) applyOverrides(overrides Overrides) {
+	if overrides.Url != "" {
+		c.Changedetection.Url = overrides.Url
+	}
+	if overrides.ApiKeyFile != "" {
+		c.Changedetection.ApiKey = ""
+		c.Changedetection.ApiKeyFile = overrides.ApiKeyFile
+	}
+}
+
+// readApiKeyFile replaces the api key with the content of the api key file, if one is configured.
+func (c *Config) readApiKeyFile() error {
+	if c.Changedetection.ApiKeyFile == "" {
+		return nil
+	}
+	content, err := os.ReadFile(c.Changedetection.ApiKeyFile)
+	if err != nil {
+		return fmt.Errorf("could not read api key file: %w", err)
+	}
+	c.Changedetection.ApiKey = strings.TrimSpace(string(content))
+	if c.Changedetection.ApiKey == "" {
+		return fmt.Errorf("api key file %s is empty", c.Changedetection.ApiKeyFile)
+	}
+	return nil
+}
+
+// Validate checks all settings and returns every problem found.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Changedetection.Url == "" {
+		errs = append(errs, fmt.Errorf("changedetection.url (CDIO_API_BASE_URL) must be set"))
+	} else if u, err := url.Parse(c.Changedetection.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("changedetection.url must be an absolute http(s) url, got %q", c.Changedetection.Url))
+	}
+	if c.Changedetection.ApiKey == "" {
+		errs = append(errs, fmt.Errorf("changedetection.api_key (CDIO_API_KEY) must be set"))
+	}
+
+	if c.Refresh.Interval < 0 {
+		errs = append(errs, fmt.Errorf("refresh.interval must not be negative, got %s", c.Refresh.Interval))
+	}
+	if c.Refresh.CacheTTL <= 0 {
+		errs = append(errs, fmt.Errorf("refresh.cache_ttl must be positive, got %s", c.Refresh.CacheTTL))
+	}
+	if c.Refresh.Concurrency <= 0 {
+		errs = append(errs, fmt.Errorf("refresh.concurrency must be positive, got %d", c.Refresh.Concurrency))
+	}
+
+	if c.Retry.MaxAttempts <= 0 {
+		errs = append(errs, fmt.Errorf("retry.max_attempts must be positive, got %d", c.Retry.MaxAttempts))
+	}
+	if c.Retry.InitialBackoff < 0 || c.Retry.MaxBackoff < 0 {
+		errs = append(errs, fmt.Errorf("retry.initial_backoff and retry.max_backoff must not be negative"))
+	}
+	if c.Retry.Jitter < 0 || c.Retry.Jitter > 1 {
+		errs = append(errs, fmt.Errorf("retry.jitter must be between 0 and 1, got %v", c.Retry.Jitter))
+	}
+
+	if c.Readiness.Window <= 0 || c.Readiness.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("readiness.window and readiness.timeout must be positive"))
+	}
+
+	if _, err := regexp.Compile(c.Watches.TagLabel); err != nil {
+		errs = append(errs, fmt.Errorf("watches.tag_label must be a regular expression: %w", err))
+	}
+
+	if (c.Prices.BaseCurrency == "") != (c.Prices.RatesFile == "") {
+		errs = append(errs, fmt.Errorf("prices.base_currency and prices.rates_file must be set together"))
+	} else if c.Prices.BaseCurrency != "" && !currencyCode.MatchString(c.Prices.BaseCurrency) {
+		errs = append(errs, fmt.Errorf("prices.base_currency must be a three letter currency code, got %q", c.Prices.BaseCurrency))
+	}
+
+	if c.Prices.HistoryWindow <= 0 {
+		errs = append(errs, fmt.Errorf("prices.history_window must be positive, got %s", c.Prices.HistoryWindow))
+	}
+
+	for name, module := range c.Modules {
+		if name == DefaultModule {
+			errs = append(errs, fmt.Errorf("modules.%s is reserved for changedetection.api_key", name))
+		}
+		if module.ApiKey == "" {
+			errs = append(errs, fmt.Errorf("modules.%s.api_key must be set", name))
+		}
+		if len(module.Targets) == 0 {
+			errs = append(errs, fmt.Errorf("modules.%s.targets must list the urls its api key may be sent to", name))
+		}
+		for _, target := range module.Targets {
+			if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, fmt.Errorf("modules.%s.targets must contain absolute http(s) urls, got %q", name, target))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ProbeModules returns every probe module, including the default one, which sends the main api
+// key to the main instance only.
+func (c *Config) ProbeModules() map[string]ModuleConfig {
+	modules := map[string]ModuleConfig{DefaultModule: {
+		ApiKey:  c.Changedetection.ApiKey,
+		Targets: []string{c.Changedetection.Url},
+	}}
+	for name, module := range c.Modules {
+		modules[name] = module
+	}
+	return modules
+}
@@ -0,0 +1,230 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+)
+
+// clearEnv unsets all environment variables read by the configuration for the duration of the test.
+func clearEnv(t *testing.T) {
+	for _, name := range []string{
+		"CDIO_API_BASE_URL", "CDIO_API_KEY", "CDIO_API_KEY_FILE", "REFRESH_INTERVAL", "CACHE_TTL", "FETCH_CONCURRENCY",
+		"RETRY_MAX_ATTEMPTS", "RETRY_INITIAL_BACKOFF", "RETRY_MAX_BACKOFF", "RETRY_JITTER",
+		"READINESS_WINDOW", "READINESS_TIMEOUT", "WATCH_TAG_LABEL", "WATCH_UUID_LABEL", "WATCH_SKIP_PAUSED",
+		"PRICE_BASE_CURRENCY", "PRICE_RATES_FILE", "PRICE_HISTORY", "PRICE_HISTORY_WINDOW",
+	} {
+		if value, ok := os.LookupEnv(name); ok {
+			os.Unsetenv(name)
+			t.Cleanup(func() { os.Setenv(name, value) })
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("TEST_CDIO_API_KEY", "secret-key")
+
+	cfg, err := Load(testutil.GetFixturePath("config/valid.yml"), Overrides{})
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, "http://changedetection:5000", cfg.Changedetection.Url)
+	testutil.Equals(t, "secret-key", cfg.Changedetection.ApiKey)
+	testutil.Equals(t, time.Minute, cfg.Refresh.Interval)
+	testutil.Equals(t, 10*time.Second, cfg.Refresh.CacheTTL)
+	testutil.Equals(t, 8, cfg.Refresh.Concurrency)
+	testutil.Equals(t, RetryConfig{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 30 * time.Second, Jitter: 0.5}, cfg.Retry)
+	testutil.Equals(t, ReadinessConfig{Window: 2 * time.Minute, Timeout: 3 * time.Second}, cfg.Readiness)
+	testutil.Equals(t, WatchesConfig{TagLabel: "^vendor:", UuidLabel: true, SkipPaused: true}, cfg.Watches)
+	testutil.Equals(t, PricesConfig{BaseCurrency: "CHF", RatesFile: "/etc/exporter/rates.yml", History: true, HistoryWindow: 30 * 24 * time.Hour}, cfg.Prices)
+	testutil.Equals(t, map[string]ModuleConfig{
+		DefaultModule: {ApiKey: "secret-key", Targets: []string{"http://changedetection:5000"}},
+		"staging":     {ApiKey: "staging-key", Targets: []string{"http://staging-1:5000", "http://staging-2:5000"}},
+	}, cfg.ProbeModules())
+}
+
+func TestLoad_AppliesDefaults(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load(testutil.GetFixturePath("config/minimal.yml"), Overrides{})
+	testutil.Ok(t, err)
+
+	defaults := Default()
+	testutil.Equals(t, defaults.Refresh, cfg.Refresh)
+	testutil.Equals(t, defaults.Retry, cfg.Retry)
+	testutil.Equals(t, defaults.Readiness, cfg.Readiness)
+	testutil.Equals(t, defaults.Prices, cfg.Prices)
+	testutil.Equals(t, "pa$word", cfg.Changedetection.ApiKey)
+}
+
+func TestLoad_KeepsLiteralDollarSigns(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("TEST_CDIO_API_KEY", "secret-key")
+
+	cfg, err := Load(testutil.GetFixturePath("config/dollar.yml"), Overrides{})
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, "s3cr$etKey", cfg.Changedetection.ApiKey)
+	testutil.Equals(t, "$1$!${TEST_CDIO_API_KEY}", cfg.Modules["staging"].ApiKey)
+	testutil.Equals(t, "secret-key", cfg.Modules["prod"].ApiKey)
+}
+
+func TestLoad_EnvironmentOverridesFile(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("TEST_CDIO_API_KEY", "secret-key")
+	t.Setenv("CDIO_API_KEY", "env-key")
+	t.Setenv("REFRESH_INTERVAL", "0")
+	t.Setenv("READINESS_TIMEOUT", "1s")
+	t.Setenv("WATCH_UUID_LABEL", "false")
+	t.Setenv("WATCH_SKIP_PAUSED", "false")
+	t.Setenv("PRICE_BASE_CURRENCY", "EUR")
+	t.Setenv("PRICE_HISTORY_WINDOW", "24h")
+	t.Setenv("CDIO_API_KEY_PROD", "prod-key")
+	t.Setenv("CDIO_TARGETS_PROD", "http://prod-1:5000, http://prod-2:5000")
+	t.Setenv("CDIO_TARGETS_STAGING", "http://staging:5000")
+
+	cfg, err := Load(testutil.GetFixturePath("config/valid.yml"), Overrides{})
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, "env-key", cfg.Changedetection.ApiKey)
+	testutil.Equals(t, time.Duration(0), cfg.Refresh.Interval)
+	testutil.Equals(t, time.Second, cfg.Readiness.Timeout)
+	testutil.Equals(t, 2*time.Minute, cfg.Readiness.Window)
+	testutil.Equals(t, false, cfg.Watches.UuidLabel)
+	testutil.Equals(t, false, cfg.Watches.SkipPaused)
+	testutil.Equals(t, "EUR", cfg.Prices.BaseCurrency)
+	testutil.Equals(t, 24*time.Hour, cfg.Prices.HistoryWindow)
+	testutil.Equals(t, true, cfg.Prices.History)
+	testutil.Equals(t, ModuleConfig{ApiKey: "prod-key", Targets: []string{"http://prod-1:5000", "http://prod-2:5000"}}, cfg.Modules["prod"])
+	testutil.Equals(t, ModuleConfig{ApiKey: "staging-key", Targets: []string{"http://staging:5000"}}, cfg.Modules["staging"])
+}
+
+func TestLoad_OverridesTakePrecedence(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("TEST_CDIO_API_KEY", "secret-key")
+	t.Setenv("CDIO_API_BASE_URL", "http://env:5000")
+	t.Setenv("CDIO_API_KEY", "env-key")
+	keyFile := writeApiKeyFile(t, "file-key\n")
+
+	cfg, err := Load(testutil.GetFixturePath("config/valid.yml"), Overrides{Url: "http://flag:5000", ApiKeyFile: keyFile})
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, "http://flag:5000", cfg.Changedetection.Url)
+	testutil.Equals(t, "file-key", cfg.Changedetection.ApiKey)
+}
+
+func TestLoad_ApiKeyFileFromEnvironment(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CDIO_API_BASE_URL", "http://localhost:5000")
+	t.Setenv("CDIO_API_KEY", "env-key")
+	t.Setenv("CDIO_API_KEY_FILE", writeApiKeyFile(t, "file-key"))
+
+	cfg, err := Load("", Overrides{})
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, "file-key", cfg.Changedetection.ApiKey)
+	_, isModule := cfg.Modules["file"]
+	testutil.Assert(t, !isModule, "expected CDIO_API_KEY_FILE not to define a module")
+}
+
+func TestLoad_EmptyApiKeyFile(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CDIO_API_BASE_URL", "http://localhost:5000")
+
+	_, err := Load("", Overrides{ApiKeyFile: writeApiKeyFile(t, " \n")})
+	testutil.Assert(t, err != nil && strings.Contains(err.Error(), "is empty"), "expected error about empty key file, got %v", err)
+}
+
+func writeApiKeyFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "api_key")
+	testutil.Ok(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoad_WithoutFile(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CDIO_API_BASE_URL", "http://localhost:5000")
+	t.Setenv("CDIO_API_KEY", "env-key")
+	t.Setenv("FETCH_CONCURRENCY", "2")
+
+	cfg, err := Load("", Overrides{})
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, "http://localhost:5000", cfg.Changedetection.Url)
+	testutil.Equals(t, 2, cfg.Refresh.Concurrency)
+}
+
+func TestLoad_MissingRequiredSettings(t *testing.T) {
+	clearEnv(t)
+
+	_, err := Load("", Overrides{})
+	testutil.Assert(t, err != nil, "expected error without url and api key")
+	testutil.Assert(t, strings.Contains(err.Error(), "changedetection.url"), "expected error about url, got %v", err)
+	testutil.Assert(t, strings.Contains(err.Error(), "changedetection.api_key"), "expected error about api key, got %v", err)
+}
+
+func TestLoad_InvalidEnvironment(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CDIO_API_BASE_URL", "http://localhost:5000")
+	t.Setenv("CDIO_API_KEY", "env-key")
+	t.Setenv("REFRESH_INTERVAL", "often")
+	t.Setenv("WATCH_UUID_LABEL", "maybe")
+
+	_, err := Load("", Overrides{})
+	testutil.Assert(t, err != nil && strings.Contains(err.Error(), "REFRESH_INTERVAL"), "expected error about REFRESH_INTERVAL, got %v", err)
+	testutil.Assert(t, strings.Contains(err.Error(), "WATCH_UUID_LABEL must be a boolean"), "expected error about WATCH_UUID_LABEL, got %v", err)
+}
+
+func TestLoad_RejectsUnknownFields(t *testing.T) {
+	clearEnv(t)
+
+	_, err := Load(testutil.GetFixturePath("config/unknown_field.yml"), Overrides{})
+	testutil.Assert(t, err != nil && strings.Contains(err.Error(), "apikey"), "expected error about unknown field, got %v", err)
+}
+
+func TestLoad_ReportsAllValidationErrors(t *testing.T) {
+	clearEnv(t)
+
+	_, err := Load(testutil.GetFixturePath("config/invalid.yml"), Overrides{})
+	testutil.Assert(t, err != nil, "expected validation errors")
+	for _, expected := range []string{
+		"changedetection.url must be an absolute http(s) url",
+		"changedetection.api_key",
+		"refresh.interval",
+		"refresh.concurrency",
+		"retry.jitter",
+		"readiness.window and readiness.timeout",
+		"watches.tag_label must be a regular expression",
+		"prices.base_currency and prices.rates_file must be set together",
+		"prices.history_window must be positive",
+		"modules.default is reserved",
+		"modules.staging.api_key",
+		"modules.staging.targets must list",
+		"modules.prod.targets must contain absolute http(s) urls",
+	} {
+		testutil.Assert(t, strings.Contains(err.Error(), expected), "expected error %q, got %v", expected, err)
+	}
+}
+
+func TestLoad_InvalidBaseCurrency(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("TEST_CDIO_API_KEY", "secret-key")
+	t.Setenv("PRICE_BASE_CURRENCY", "Swiss francs")
+
+	_, err := Load(testutil.GetFixturePath("config/valid.yml"), Overrides{})
+	testutil.Assert(t, err != nil, "expected validation error")
+	testutil.Assert(t, strings.Contains(err.Error(), "three letter currency code"), "expected error about the currency code, got %v", err)
+}
+
+func TestLoad_MissingFile(t *testing.T) {
+	clearEnv(t)
+
+	_, err := Load(testutil.GetFixturePath("config/does-not-exist.yml"), Overrides{})
+	testutil.Assert(t, os.IsNotExist(err), "expected not exist error, got %v", err)
+}
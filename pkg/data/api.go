@@ -3,32 +3,96 @@
 package data
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/url"
+	"strings"
 )
 
-type StringBoolean bool
+// LastError is the error of the last check of a watch. changedetection.io reports it as false if
+// the check succeeded, and as the error message otherwise.
+type LastError struct {
+	Message string
+}
+
+// Failed reports whether the last check of the watch failed.
+func (e LastError) Failed() bool {
+	return e.Message != ""
+}
 
-func (sb *StringBoolean) UnmarshalJSON(data []byte) error {
-	if string(data) == "false" {
-		*sb = false
-	} else {
-		*sb = true
+func (e *LastError) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	switch string(trimmed) {
+	case "false", "null", `""`:
+		*e = LastError{}
+		return nil
 	}
+
+	var message string
+	if err := json.Unmarshal(trimmed, &message); err != nil {
+		// anything but false still means the check failed, keep its raw value as message
+		message = string(trimmed)
+	}
+	*e = LastError{Message: message}
 	return nil
 }
 
+func (e LastError) MarshalJSON() ([]byte, error) {
+	if !e.Failed() {
+		return []byte("false"), nil
+	}
+	return json.Marshal(e.Message)
+}
+
 type WatchItem struct {
-	LastChanged            int64         `json:"last_changed"`
-	LastChecked            int64         `json:"last_checked"`
-	LastError              StringBoolean `json:"last_error"`
-	Title                  string        `json:"title"`
-	Url                    string        `json:"url"`
-	CheckCount             int           `json:"check_count,omitempty"`
-	FetchTime              float64       `json:"fetch_time,omitempty"`
-	NotificationAlertCount int           `json:"notification_alert_count,omitempty"`
-	LastCheckStatus        int           `json:"last_check_status,omitempty"`
-	PriceData              *PriceData    `json:"price,omitempty"`
+	LastChanged            int64        `json:"last_changed"`
+	LastChecked            int64        `json:"last_checked"`
+	LastError              LastError    `json:"last_error"`
+	Title                  string       `json:"title"`
+	Url                    string       `json:"url"`
+	CheckCount             int          `json:"check_count,omitempty"`
+	FetchTime              float64      `json:"fetch_time,omitempty"`
+	NotificationAlertCount int          `json:"notification_alert_count,omitempty"`
+	LastCheckStatus        int          `json:"last_check_status,omitempty"`
+	PriceData              *PriceData   `json:"price,omitempty"`
+	Tags                   []string     `json:"tags,omitempty"`
+	Processor              string       `json:"processor,omitempty"`
+	FetchBackend           string       `json:"fetch_backend,omitempty"`
+	Paused                 bool         `json:"paused"`
+	NotificationMuted      bool         `json:"notification_muted"`
+	Restock                *RestockData `json:"restock,omitempty"`
+}
+
+// Processors a watch can use to detect changes.
+const (
+	ProcessorTextJsonDiff = "text_json_diff"
+	ProcessorRestockDiff  = "restock_diff"
+)
+
+// RestockData is the state of a watch using the restock detection processor, its fields are null
+// until they could be detected.
+type RestockData struct {
+	InStock       *bool    `json:"in_stock"`
+	Price         *float64 `json:"price"`
+	Currency      string   `json:"currency"`
+	OriginalPrice *float64 `json:"original_price"`
+}
+
+// Availability returns the stock state as one of Availabilities, or false if it is unknown.
+func (r *RestockData) Availability() (string, bool) {
+	if r.InStock == nil {
+		return "", false
+	}
+	if *r.InStock {
+		return "InStock", true
+	}
+	return "OutOfStock", true
+}
+
+type Tag struct {
+	Uuid  string `json:"uuid"`
+	Title string `json:"title"`
 }
 
 type PriceData struct {
@@ -37,6 +101,26 @@ type PriceData struct {
 	Availability string  `json:"availability"`
 }
 
+// Availabilities lists the schema.org item availabilities NormalizedAvailability maps to.
+var Availabilities = []string{"InStock", "OutOfStock", "PreOrder", "BackOrder", "Discontinued", "LimitedAvailability"}
+
+// NormalizedAvailability returns the availability of the offer as one of Availabilities. Values
+// are matched ignoring case, separators and a schema.org url prefix (i.e. "https://schema.org/InStock"
+// or "in_stock"). It returns false if the availability is missing or unknown.
+func (p *PriceData) NormalizedAvailability() (string, bool) {
+	value := p.Availability
+	if i := strings.LastIndex(value, "/"); i >= 0 {
+		value = value[i+1:]
+	}
+	value = strings.NewReplacer("_", "", "-", "", " ", "").Replace(value)
+	for _, availability := range Availabilities {
+		if strings.EqualFold(value, availability) {
+			return availability, true
+		}
+	}
+	return "", false
+}
+
 type SystemInfo struct {
 	Version        string   `json:"version"`
 	Uptime         float64  `json:"uptime"`
@@ -58,3 +142,16 @@ func (w *WatchItem) GetMetrics() ([]string, error) {
 	}
 	return []string{w.Title, url.Host}, nil
 }
+
+// RedactUrl removes credentials, the query and the fragment from the url, so it can be shown to
+// anyone. It returns an empty string if the url is invalid.
+func RedactUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
@@ -0,0 +1,196 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package rates
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrMissingCurrency is returned when converting an amount without currency.
+	ErrMissingCurrency = errors.New("currency is missing")
+	// ErrUnknownCurrency is returned when converting from or to a currency without exchange rate.
+	ErrUnknownCurrency = errors.New("unknown currency")
+)
+
+// Table contains exchange rates relative to a base currency.
+type Table struct {
+	// Base is the currency all rates are relative to.
+	Base string
+	// Rates contains the amount of each currency one unit of the base currency is worth.
+	Rates map[string]float64
+}
+
+// yamlTable is the layout of a rates file in yaml format.
+type yamlTable struct {
+	Base  string             `yaml:"base"`
+	Rates map[string]float64 `yaml:"rates"`
+}
+
+// ecbEnvelope is the layout of the reference rates published by the European Central Bank (i.e.
+// https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml), which are relative to EUR.
+type ecbEnvelope struct {
+	Days []struct {
+		Rates []struct {
+			Currency string  `xml:"currency,attr"`
+			Rate     float64 `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+// Parse reads a rates table in the ECB xml format or the yaml format.
+func Parse(content []byte) (*Table, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("<")) {
+		return parseEcb(content)
+	}
+	return parseYaml(content)
+}
+
+func parseEcb(content []byte) (*Table, error) {
+	var envelope ecbEnvelope
+	if err := xml.Unmarshal(content, &envelope); err != nil {
+		return nil, err
+	}
+	if len(envelope.Days) == 0 {
+		return nil, fmt.Errorf("no exchange rates found")
+	}
+
+	// the most recent rates come first
+	table := &Table{Base: "EUR", Rates: make(map[string]float64)}
+	for _, rate := range envelope.Days[0].Rates {
+		table.Rates[strings.ToUpper(rate.Currency)] = rate.Rate
+	}
+	return table, table.validate()
+}
+
+func parseYaml(content []byte) (*Table, error) {
+	var raw yamlTable
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	if raw.Base == "" {
+		return nil, fmt.Errorf("base must be set")
+	}
+
+	table := &Table{Base: strings.ToUpper(raw.Base), Rates: make(map[string]float64)}
+	for currency, rate := range raw.Rates {
+		table.Rates[strings.ToUpper(currency)] = rate
+	}
+	return table, table.validate()
+}
+
+func (t *Table) validate() error {
+	for currency, rate := range t.Rates {
+		if rate <= 0 {
+			return fmt.Errorf("rate of %s must be positive, got %v", currency, rate)
+		}
+	}
+	if rate, ok := t.Rates[t.Base]; ok && rate != 1 {
+		return fmt.Errorf("rate of the base currency %s must be 1, got %v", t.Base, rate)
+	}
+	t.Rates[t.Base] = 1
+	return nil
+}
+
+// Convert converts amount from one currency to another.
+func (t *Table) Convert(amount float64, from string, to string) (float64, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	if from == "" {
+		return 0, ErrMissingCurrency
+	}
+	if from == to {
+		return amount, nil
+	}
+
+	fromRate, ok := t.Rates[from]
+	if !ok {
+		return 0, fmt.Errorf("%w %s", ErrUnknownCurrency, from)
+	}
+	toRate, ok := t.Rates[to]
+	if !ok {
+		return 0, fmt.Errorf("%w %s", ErrUnknownCurrency, to)
+	}
+	return amount / fromRate * toRate, nil
+}
+
+// File is a rates table read from a file, which is read again once the file changes.
+type File struct {
+	mu       sync.Mutex
+	path     string
+	required []string
+	modTime  time.Time
+	size     int64
+	table    *Table
+}
+
+// NewFile reads the rates table at path, which must contain an exchange rate for each of the
+// required currencies.
+func NewFile(path string, required ...string) (*File, error) {
+	f := &File{path: path}
+	for _, currency := range required {
+		f.required = append(f.required, strings.ToUpper(currency))
+	}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Table returns the rates table, after reading the file again if it changed. If the changed file
+// cannot be read or lacks a required currency, the previous table is kept.
+func (f *File) Table() *Table {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		log.Errorf("could not check exchange rates file: %v", err)
+		return f.table
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.table
+	}
+	if err := f.reload(); err != nil {
+		// don't try again until the file changes another time
+		f.modTime, f.size = info.ModTime(), info.Size()
+		log.Errorf("keeping previous exchange rates: %v", err)
+		return f.table
+	}
+	log.Infof("reloaded exchange rates from %s", f.path)
+	return f.table
+}
+
+func (f *File) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("could not read exchange rates file: %w", err)
+	}
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("could not read exchange rates file: %w", err)
+	}
+	table, err := Parse(content)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	for _, currency := range f.required {
+		if _, ok := table.Rates[currency]; !ok {
+			return fmt.Errorf("%s: %w %s", f.path, ErrUnknownCurrency, currency)
+		}
+	}
+
+	f.table, f.modTime, f.size = table, info.ModTime(), info.Size()
+	return nil
+}
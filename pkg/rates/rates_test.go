@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package rates
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+)
+
+func TestParse_Ecb(t *testing.T) {
+	content, err := os.ReadFile(testutil.GetFixturePath("rates/eurofxref-daily.xml"))
+	testutil.Ok(t, err)
+
+	table, err := Parse(content)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "EUR", table.Base)
+	testutil.Equals(t, map[string]float64{"EUR": 1, "USD": 1.0745, "JPY": 164.62, "GBP": 0.8574, "CHF": 0.9748}, table.Rates)
+}
+
+func TestParse_Yaml(t *testing.T) {
+	content, err := os.ReadFile(testutil.GetFixturePath("rates/rates.yml"))
+	testutil.Ok(t, err)
+
+	table, err := Parse(content)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "CHF", table.Base)
+	testutil.Equals(t, map[string]float64{"CHF": 1, "USD": 1.25, "EUR": 1}, table.Rates)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	content, err := os.ReadFile(testutil.GetFixturePath("rates/invalid.yml"))
+	testutil.Ok(t, err)
+
+	_, err = Parse(content)
+	testutil.Assert(t, err != nil, "expected negative rate to be rejected")
+
+	_, err = Parse([]byte("rates:\n  USD: 1.1\n"))
+	testutil.Assert(t, err != nil, "expected missing base to be rejected")
+
+	_, err = Parse([]byte("<Envelope></Envelope>"))
+	testutil.Assert(t, err != nil, "expected empty xml to be rejected")
+}
+
+func TestTable_Convert(t *testing.T) {
+	table := &Table{Base: "EUR", Rates: map[string]float64{"EUR": 1, "USD": 1.25, "CHF": 0.5}}
+
+	converted, err := table.Convert(125, "USD", "EUR")
+	testutil.Ok(t, err)
+	testutil.Equals(t, 100.0, converted)
+
+	// cross rates are derived from the base currency
+	converted, err = table.Convert(125, "usd", "CHF")
+	testutil.Ok(t, err)
+	testutil.Equals(t, 50.0, converted)
+
+	converted, err = table.Convert(42, "XYZ", "XYZ")
+	testutil.Ok(t, err)
+	testutil.Equals(t, 42.0, converted)
+}
+
+func TestTable_Convert_MissingCurrency(t *testing.T) {
+	table := &Table{Base: "EUR", Rates: map[string]float64{"EUR": 1}}
+
+	_, err := table.Convert(100, "", "EUR")
+	testutil.Assert(t, errors.Is(err, ErrMissingCurrency), "expected ErrMissingCurrency, got %v", err)
+}
+
+func TestTable_Convert_UnknownCurrency(t *testing.T) {
+	table := &Table{Base: "EUR", Rates: map[string]float64{"EUR": 1, "USD": 1.25}}
+
+	_, err := table.Convert(100, "XYZ", "EUR")
+	testutil.Assert(t, errors.Is(err, ErrUnknownCurrency), "expected ErrUnknownCurrency, got %v", err)
+
+	_, err = table.Convert(100, "USD", "XYZ")
+	testutil.Assert(t, errors.Is(err, ErrUnknownCurrency), "expected ErrUnknownCurrency, got %v", err)
+}
+
+func TestNewFile_Missing(t *testing.T) {
+	_, err := NewFile(filepath.Join(t.TempDir(), "missing.yml"))
+	testutil.Assert(t, err != nil, "expected missing file to be rejected")
+}
+
+func TestFile_ReloadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.yml")
+	testutil.Ok(t, os.WriteFile(path, []byte("base: EUR\nrates:\n  USD: 1.25\n"), 0o600))
+
+	f, err := NewFile(path)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1.25, f.Table().Rates["USD"])
+
+	testutil.Ok(t, os.WriteFile(path, []byte("base: EUR\nrates:\n  USD: 1.5\n"), 0o600))
+	touch(t, path, time.Minute)
+	testutil.Equals(t, 1.5, f.Table().Rates["USD"])
+
+	// invalid changes keep the previous rates
+	testutil.Ok(t, os.WriteFile(path, []byte("base: EUR\nrates:\n  USD: nope\n"), 0o600))
+	touch(t, path, 2*time.Minute)
+	testutil.Equals(t, 1.5, f.Table().Rates["USD"])
+
+	testutil.Ok(t, os.Remove(path))
+	testutil.Equals(t, 1.5, f.Table().Rates["USD"])
+}
+
+func TestNewFile_RequiredCurrency(t *testing.T) {
+	_, err := NewFile(testutil.GetFixturePath("rates/rates.yml"), "usd")
+	testutil.Ok(t, err)
+
+	_, err = NewFile(testutil.GetFixturePath("rates/rates.yml"), "JPY")
+	testutil.Assert(t, errors.Is(err, ErrUnknownCurrency), "expected missing currency to be rejected, got %v", err)
+}
+
+func TestFile_KeepsTableWithRequiredCurrency(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.yml")
+	testutil.Ok(t, os.WriteFile(path, []byte("base: EUR\nrates:\n  USD: 1.25\n  CHF: 0.5\n"), 0o600))
+
+	f, err := NewFile(path, "CHF")
+	testutil.Ok(t, err)
+
+	// changes without the required currency keep the previous rates
+	testutil.Ok(t, os.WriteFile(path, []byte("base: EUR\nrates:\n  USD: 1.5\n"), 0o600))
+	touch(t, path, time.Minute)
+	testutil.Equals(t, 1.25, f.Table().Rates["USD"])
+	testutil.Equals(t, 0.5, f.Table().Rates["CHF"])
+}
+
+// touch moves the modification time of path forward, so changes are detected on file systems with
+// a coarse timestamp resolution.
+func touch(t *testing.T, path string, offset time.Duration) {
+	t.Helper()
+	modTime := time.Now().Add(offset)
+	testutil.Ok(t, os.Chtimes(path, modTime, modTime))
+}
@@ -0,0 +1,282 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/collectors"
+	"github.com/schaermu/changedetection.io-exporter/pkg/config"
+	"github.com/schaermu/changedetection.io-exporter/pkg/rates"
+	log "github.com/sirupsen/logrus"
+)
+
+// reloadTimeout limits how long a reload waits for the first snapshot of the new configuration.
+const reloadTimeout = 30 * time.Second
+
+// Exporter bundles the client, store and collectors built from a single configuration.
+type Exporter struct {
+	Config *config.Config
+	Store  *collectors.Store
+
+	// priceHistory is passed on to the exporter replacing this one on reload, if possible.
+	priceHistory *collectors.PriceHistoryCache
+
+	metrics http.Handler
+	probe   http.Handler
+	ready   http.Handler
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewExporter builds an exporter from cfg. Its metrics are served along with the ones of static,
+// which contains collectors living as long as the process (i.e. go runtime metrics).
+func NewExporter(cfg *config.Config, static prometheus.Gatherer, clientMetrics *cdio.ClientMetrics) (*Exporter, error) {
+	return newExporter(cfg, static, clientMetrics, nil)
+}
+
+// newExporter builds an exporter from cfg, which takes over the cached price history of previous
+// unless the instance or the history settings changed.
+func newExporter(cfg *config.Config, static prometheus.Gatherer, clientMetrics *cdio.ClientMetrics, previous *Exporter) (*Exporter, error) {
+	retry := cdio.RetryPolicy{
+		MaxAttempts:    cfg.Retry.MaxAttempts,
+		InitialBackoff: cfg.Retry.InitialBackoff,
+		MaxBackoff:     cfg.Retry.MaxBackoff,
+		Jitter:         cfg.Retry.Jitter,
+	}
+	client := cdio.NewApiClient(cfg.Changedetection.Url, cfg.Changedetection.ApiKey)
+	client.Retry = retry
+	client.Metrics = clientMetrics
+
+	storeOptions := []collectors.StoreOption{collectors.WithConcurrency(cfg.Refresh.Concurrency)}
+	if cfg.Refresh.Interval == 0 {
+		// without background refresh, scrapes share a short-lived snapshot
+		storeOptions = append(storeOptions, collectors.WithMaxAge(cfg.Refresh.CacheTTL))
+	}
+	if cfg.Watches.SkipPaused {
+		storeOptions = append(storeOptions, collectors.WithSkipPaused())
+	}
+	var priceHistory *collectors.PriceHistoryCache
+	if cfg.Prices.History {
+		if previous.sharesPriceHistory(cfg) {
+			priceHistory = previous.priceHistory
+		} else {
+			priceHistory = collectors.NewPriceHistoryCache()
+		}
+		storeOptions = append(storeOptions, collectors.WithPriceHistory(priceHistory, cfg.Prices.HistoryWindow))
+	}
+	store := collectors.NewStore(client, storeOptions...)
+	probeStoreOptions := []collectors.StoreOption{collectors.WithConcurrency(cfg.Refresh.Concurrency)}
+	if cfg.Watches.SkipPaused {
+		probeStoreOptions = append(probeStoreOptions, collectors.WithSkipPaused())
+	}
+	collectorOptions := newCollectorOptions(cfg)
+	if cfg.Prices.RatesFile != "" {
+		table, err := rates.NewFile(cfg.Prices.RatesFile, cfg.Prices.BaseCurrency)
+		if err != nil {
+			return nil, err
+		}
+		collectorOptions = append(collectorOptions, collectors.WithBaseCurrency(cfg.Prices.BaseCurrency, table))
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	for _, c := range []prometheus.Collector{
+		collectors.NewSystemCollector(store),
+		collectors.NewWatchCollector(store, collectorOptions...),
+		collectors.NewPriceCollector(store, collectorOptions...),
+		collectors.NewRefreshCollector(store),
+	} {
+		if err := registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Exporter{
+		Config:       cfg,
+		Store:        store,
+		priceHistory: priceHistory,
+		metrics:      MetricsHandler(store, prometheus.Gatherers{static, registry}),
+		probe: ProbeHandler(ProbeOptions{
+			Modules:          cfg.ProbeModules(),
+			Retry:            retry,
+			Metrics:          clientMetrics,
+			StoreOptions:     probeStoreOptions,
+			CollectorOptions: collectorOptions,
+		}),
+		ready: ReadyHandler(NewReadinessCheck(client, cfg.Readiness.Window, cfg.Readiness.Timeout)),
+	}, nil
+}
+
+// sharesPriceHistory reports whether an exporter built from cfg may take over the cached price
+// history of e. The cache only keeps the prices within the window, so it cannot grow.
+func (e *Exporter) sharesPriceHistory(cfg *config.Config) bool {
+	return e != nil && e.priceHistory != nil &&
+		e.Config.Changedetection.Url == cfg.Changedetection.Url &&
+		e.Config.Changedetection.ApiKey == cfg.Changedetection.ApiKey &&
+		e.Config.Prices.HistoryWindow >= cfg.Prices.HistoryWindow
+}
+
+// newCollectorOptions returns the options of the watch and price collectors set in cfg.
+func newCollectorOptions(cfg *config.Config) []collectors.CollectorOption {
+	var options []collectors.CollectorOption
+	if cfg.Watches.TagLabel != "" {
+		// validated when loading the configuration
+		options = append(options, collectors.WithTagLabel(regexp.MustCompile(cfg.Watches.TagLabel)))
+	}
+	if cfg.Watches.UuidLabel {
+		options = append(options, collectors.WithUuidLabel())
+	}
+	return options
+}
+
+// Start begins refreshing the snapshot in the background, if configured to do so.
+func (e *Exporter) Start() {
+	if e.Config.Refresh.Interval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.done = make(chan struct{})
+	go func() {
+		defer close(e.done)
+		e.Store.Run(ctx, e.Config.Refresh.Interval)
+	}()
+}
+
+// Stop ends the background refresh and waits for it to finish.
+func (e *Exporter) Stop() {
+	if e.cancel != nil {
+		e.cancel()
+		<-e.done
+	}
+}
+
+// Reloader serves the endpoints of the current exporter and replaces it atomically whenever the
+// configuration is reloaded. Requests in flight keep using the exporter they started with.
+type Reloader struct {
+	mu            sync.Mutex
+	current       atomic.Pointer[Exporter]
+	load          func() (*config.Config, error)
+	static        prometheus.Gatherer
+	clientMetrics *cdio.ClientMetrics
+	stopped       bool
+}
+
+// NewReloader loads the initial configuration using load and starts the exporter built from it.
+func NewReloader(load func() (*config.Config, error), static prometheus.Gatherer, clientMetrics *cdio.ClientMetrics) (*Reloader, error) {
+	r := &Reloader{
+		load:          load,
+		static:        static,
+		clientMetrics: clientMetrics,
+	}
+
+	cfg, err := load()
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := NewExporter(cfg, static, clientMetrics)
+	if err != nil {
+		return nil, err
+	}
+	exporter.Start()
+	r.current.Store(exporter)
+	return r, nil
+}
+
+// Current returns the exporter currently serving requests.
+func (r *Reloader) Current() *Exporter {
+	return r.current.Load()
+}
+
+// Reload loads the configuration again and swaps the current exporter for one built from it. If
+// the configuration is invalid, the current exporter keeps running.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopped {
+		return errors.New("exporter is shutting down")
+	}
+
+	cfg, err := r.load()
+	if err != nil {
+		return fmt.Errorf("could not load configuration: %w", err)
+	}
+	exporter, err := newExporter(cfg, r.static, r.clientMetrics, r.Current())
+	if err != nil {
+		return fmt.Errorf("could not build exporter: %w", err)
+	}
+	exporter.Start()
+
+	// give the new exporter a chance to fetch its data, so scrapes do not see a gap
+	if cfg.Refresh.Interval > 0 {
+		select {
+		case <-exporter.Store.Ready():
+		case <-time.After(reloadTimeout):
+			log.Warnf("no data fetched within %s of reload, swapping anyway", reloadTimeout)
+		}
+	}
+
+	old := r.current.Swap(exporter)
+	old.Stop()
+	log.Info("configuration reloaded")
+	return nil
+}
+
+// Stop stops the background refresh of the current exporter. Reloads afterwards fail, so no new
+// exporter is started while shutting down.
+func (r *Reloader) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stopped = true
+	r.Current().Stop()
+}
+
+// MetricsHandler serves the metrics of the current exporter.
+func (r *Reloader) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.Current().metrics.ServeHTTP(w, req)
+	})
+}
+
+// ProbeHandler serves probes using the modules of the current exporter.
+func (r *Reloader) ProbeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.Current().probe.ServeHTTP(w, req)
+	})
+}
+
+// ReadyHandler checks the readiness of the current exporter.
+func (r *Reloader) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.Current().ready.ServeHTTP(w, req)
+	})
+}
+
+// ReloadHandler triggers a reload on POST requests.
+func (r *Reloader) ReloadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost && req.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.Reload(); err != nil {
+			log.Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,224 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/collectors"
+	"github.com/schaermu/changedetection.io-exporter/pkg/config"
+	"github.com/schaermu/changedetection.io-exporter/pkg/data"
+)
+
+func newTestConfig(url string) *config.Config {
+	cfg := config.Default()
+	cfg.Changedetection.Url = url
+	cfg.Changedetection.ApiKey = "foo-bar-key"
+	cfg.Refresh.Interval = time.Minute
+	cfg.Retry.MaxAttempts = 1
+	return cfg
+}
+
+func newTestApiServer(t *testing.T, version string) *testutil.ApiTestServer {
+	_, watchDb := testutil.NewCollectorTestDb()
+	return testutil.CreateTestApiServer(t, watchDb, testutil.WithSystemInfo(&data.SystemInfo{Version: version}))
+}
+
+func scrape(handler http.Handler) string {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return rec.Body.String()
+}
+
+func TestReloader_Reload(t *testing.T) {
+	first := newTestApiServer(t, "1.0.0")
+	defer first.Close()
+	second := newTestApiServer(t, "2.0.0")
+	defer second.Close()
+
+	cfg := newTestConfig(first.URL())
+	reloader, err := NewReloader(func() (*config.Config, error) { return cfg, nil }, prometheus.NewRegistry(), cdio.NewClientMetrics())
+	testutil.Ok(t, err)
+	defer reloader.Current().Stop()
+	<-reloader.Current().Store.Ready()
+
+	testutil.Assert(t, strings.Contains(scrape(reloader.MetricsHandler()), `version="1.0.0"`), "expected metrics of first instance")
+	old := reloader.Current()
+
+	cfg = newTestConfig(second.URL())
+	testutil.Ok(t, reloader.Reload())
+
+	// the new instance is served right away, without a gap
+	testutil.Assert(t, reloader.Current() != old, "expected exporter to be swapped")
+	testutil.Assert(t, strings.Contains(scrape(reloader.MetricsHandler()), `version="2.0.0"`), "expected metrics of second instance")
+
+	// scrapes still holding the old exporter finish with its data
+	testutil.Assert(t, strings.Contains(scrape(old.metrics), `version="1.0.0"`), "expected old exporter to keep serving")
+}
+
+func TestReloader_KeepsExporterOnInvalidConfig(t *testing.T) {
+	server := newTestApiServer(t, "1.0.0")
+	defer server.Close()
+
+	var loadErr error
+	cfg := newTestConfig(server.URL())
+	reloader, err := NewReloader(func() (*config.Config, error) { return cfg, loadErr }, prometheus.NewRegistry(), cdio.NewClientMetrics())
+	testutil.Ok(t, err)
+	defer reloader.Current().Stop()
+	current := reloader.Current()
+
+	loadErr = errors.New("broken config")
+	err = reloader.Reload()
+
+	testutil.Assert(t, err != nil && strings.Contains(err.Error(), "broken config"), "expected reload to fail, got %v", err)
+	testutil.Assert(t, reloader.Current() == current, "expected exporter to be kept")
+}
+
+func TestReloader_ServesStaticMetrics(t *testing.T) {
+	server := newTestApiServer(t, "1.0.0")
+	defer server.Close()
+
+	static := prometheus.NewRegistry()
+	clientMetrics := cdio.NewClientMetrics()
+	static.MustRegister(clientMetrics)
+	cfg := newTestConfig(server.URL())
+	reloader, err := NewReloader(func() (*config.Config, error) { return cfg, nil }, static, clientMetrics)
+	testutil.Ok(t, err)
+	defer reloader.Current().Stop()
+	<-reloader.Current().Store.Ready()
+
+	body := scrape(reloader.MetricsHandler())
+	testutil.Assert(t, strings.Contains(body, "changedetectionio_exporter_api_requests_total"), "expected static metrics")
+	testutil.Assert(t, strings.Contains(body, "changedetectionio_watch_check_count"), "expected collector metrics")
+}
+
+func TestReloader_ReloadHandler(t *testing.T) {
+	server := newTestApiServer(t, "1.0.0")
+	defer server.Close()
+
+	cfg := newTestConfig(server.URL())
+	cfg.Refresh.Interval = 0
+	reloader, err := NewReloader(func() (*config.Config, error) { return cfg, nil }, prometheus.NewRegistry(), cdio.NewClientMetrics())
+	testutil.Ok(t, err)
+	current := reloader.Current()
+
+	rec := httptest.NewRecorder()
+	reloader.ReloadHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/reload", nil))
+	testutil.Equals(t, http.StatusMethodNotAllowed, rec.Code)
+	testutil.Assert(t, reloader.Current() == current, "expected GET not to reload")
+
+	rec = httptest.NewRecorder()
+	reloader.ReloadHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+	testutil.Equals(t, http.StatusOK, rec.Code)
+	testutil.Assert(t, reloader.Current() != current, "expected POST to reload")
+}
+
+func TestReloader_KeepsPriceHistory(t *testing.T) {
+	now := time.Now()
+	watchDb := make(map[string]*data.WatchItem)
+	uuid, watch := testutil.NewTestItem("Item 1", 90, "USD", 20, 15, 10)
+	watchDb[uuid] = watch
+	snapshots := map[int64]*data.PriceData{
+		now.Add(-48 * time.Hour).Unix(): {Price: 100, Currency: "USD"},
+		now.Add(-24 * time.Hour).Unix(): {Price: 90, Currency: "USD"},
+	}
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithHistory(uuid, snapshots))
+	defer server.Close()
+
+	cfg := newTestConfig(server.URL())
+	cfg.Prices.History = true
+	reloader, err := NewReloader(func() (*config.Config, error) { return cfg, nil }, prometheus.NewRegistry(), cdio.NewClientMetrics())
+	testutil.Ok(t, err)
+	defer reloader.Current().Stop()
+	waitForPriceStats(t, reloader, uuid)
+
+	// the same instance keeps the cached snapshots
+	testutil.Ok(t, reloader.Reload())
+	testutil.Equals(t, 2, waitForPriceStats(t, reloader, uuid).All.Count)
+	for timestamp := range snapshots {
+		testutil.Equals(t, 1, server.RequestCount(fmt.Sprintf("/api/v1/watch/%s/history/%d", uuid, timestamp)))
+	}
+
+	// a longer window needs snapshots the cache has dropped already
+	cfg = newTestConfig(server.URL())
+	cfg.Prices.History = true
+	cfg.Prices.HistoryWindow *= 2
+	testutil.Ok(t, reloader.Reload())
+	waitForPriceStats(t, reloader, uuid)
+	for timestamp := range snapshots {
+		testutil.Equals(t, 2, server.RequestCount(fmt.Sprintf("/api/v1/watch/%s/history/%d", uuid, timestamp)))
+	}
+}
+
+// waitForPriceStats waits until the current exporter has published the price statistics of the
+// watch with the given uuid, which happens after its first snapshot is ready.
+func waitForPriceStats(t *testing.T, reloader *Reloader, uuid string) *collectors.PriceStats {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if stats, ok := reloader.Current().Store.Latest().PriceStats[uuid]; ok {
+			return stats
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("no price statistics of watch %s published", uuid)
+	return nil
+}
+
+func TestNewReloader_InvalidConfig(t *testing.T) {
+	_, err := NewReloader(func() (*config.Config, error) { return nil, errors.New("broken config") }, prometheus.NewRegistry(), cdio.NewClientMetrics())
+	testutil.Assert(t, err != nil, "expected error for invalid initial config")
+}
+
+func TestReloader_Stop(t *testing.T) {
+	server := newTestApiServer(t, "1.0.0")
+	defer server.Close()
+
+	cfg := newTestConfig(server.URL())
+	reloader, err := NewReloader(func() (*config.Config, error) { return cfg, nil }, prometheus.NewRegistry(), cdio.NewClientMetrics())
+	testutil.Ok(t, err)
+	current := reloader.Current()
+
+	reloader.Stop()
+
+	select {
+	case <-current.done:
+	default:
+		t.Fatal("expected background refresh to be stopped")
+	}
+	err = reloader.Reload()
+	testutil.Assert(t, err != nil && strings.Contains(err.Error(), "shutting down"), "expected reload to fail, got %v", err)
+	testutil.Equals(t, current, reloader.Current())
+}
+
+func TestNewExporter_BaseCurrency(t *testing.T) {
+	server := newTestApiServer(t, "1.0.0")
+	defer server.Close()
+
+	cfg := newTestConfig(server.URL())
+	cfg.Refresh.Interval = 0
+	cfg.Prices.BaseCurrency = "CHF"
+	cfg.Prices.RatesFile = testutil.GetFixturePath("rates/rates.yml")
+	exporter, err := NewExporter(cfg, prometheus.NewRegistry(), cdio.NewClientMetrics())
+	testutil.Ok(t, err)
+
+	body := scrape(exporter.metrics)
+	testutil.Assert(t, strings.Contains(body, `changedetectionio_watch_price_base{currency="CHF",source="www.item-1.org",title="Item 1"} 80`), "expected converted price, got %s", body)
+
+	cfg.Prices.RatesFile = testutil.GetFixturePath("rates/missing.yml")
+	_, err = NewExporter(cfg, prometheus.NewRegistry(), cdio.NewClientMetrics())
+	testutil.Assert(t, err != nil, "expected error for missing rates file")
+
+	cfg.Prices.BaseCurrency = "JPY"
+	cfg.Prices.RatesFile = testutil.GetFixturePath("rates/rates.yml")
+	_, err = NewExporter(cfg, prometheus.NewRegistry(), cdio.NewClientMetrics())
+	testutil.Assert(t, err != nil, "expected error for base currency missing from the rates file")
+}
@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"golang.org/x/sync/singleflight"
+)
+
+// ReadinessCheck reports whether the changedetection.io API could be reached and accepted the api
+// key recently. A successful check is cached for the configured window, so orchestrators probing
+// the exporter frequently do not hit the API every time. A failed check is cached for the timeout,
+// so during an outage the API is not contacted more often than once per timeout either.
+type ReadinessCheck struct {
+	mu          sync.Mutex
+	group       singleflight.Group
+	client      *cdio.ApiClient
+	window      time.Duration
+	timeout     time.Duration
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastErr     error
+}
+
+func NewReadinessCheck(client *cdio.ApiClient, window time.Duration, timeout time.Duration) *ReadinessCheck {
+	return &ReadinessCheck{
+		client:  client,
+		window:  window,
+		timeout: timeout,
+	}
+}
+
+// Check returns nil if the API has been reached successfully within the window, or the error of a
+// check failed within the timeout. Otherwise, it requests the system info of the instance,
+// concurrent callers share the same request.
+func (c *ReadinessCheck) Check(ctx context.Context) error {
+	c.mu.Lock()
+	if !c.lastSuccess.IsZero() && time.Since(c.lastSuccess) < c.window {
+		c.mu.Unlock()
+		return nil
+	}
+	if c.lastErr != nil && time.Since(c.lastFailure) < c.timeout {
+		err := c.lastErr
+		c.mu.Unlock()
+		return err
+	}
+	c.mu.Unlock()
+
+	// the request is shared, so it must not be canceled along with the caller that started it
+	result := c.group.DoChan("check", func() (interface{}, error) {
+		return nil, c.check(context.WithoutCancel(ctx))
+	})
+	select {
+	case res := <-result:
+		return res.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *ReadinessCheck) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	_, err := c.client.GetSystemInfoContext(ctx)
+	if errors.Is(err, cdio.ErrUnauthorized) || errors.Is(err, cdio.ErrForbidden) {
+		err = fmt.Errorf("api key was rejected: %w", err)
+	} else if err != nil {
+		err = fmt.Errorf("api could not be reached (%s): %w", cdio.ErrorReason(err), err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.lastFailure, c.lastErr = time.Now(), err
+		return err
+	}
+	c.lastSuccess, c.lastErr = time.Now(), nil
+	return nil
+}
+
+// HealthyHandler reports that the exporter process is alive.
+func HealthyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Healthy")
+	})
+}
+
+// ReadyHandler reports whether the exporter is ready to serve metrics, answering with 503 if the
+// readiness check fails.
+func ReadyHandler(check *ReadinessCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := check.Check(r.Context()); err != nil {
+			http.Error(w, fmt.Sprintf("Not ready: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "Ready")
+	})
+}
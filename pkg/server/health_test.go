@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+)
+
+func checkReady(handler http.Handler) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+	return rec
+}
+
+func TestHealthyHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	HealthyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/healthy", nil))
+
+	testutil.Equals(t, http.StatusOK, rec.Code)
+}
+
+func TestReadyHandler_CachesSuccess(t *testing.T) {
+	server := newTestApiServer(t, "1.0.0")
+	defer server.Close()
+
+	handler := ReadyHandler(NewReadinessCheck(cdio.NewTestApiClient(server.URL()), time.Minute, time.Second))
+
+	for i := 0; i < 3; i++ {
+		rec := checkReady(handler)
+		testutil.Equals(t, http.StatusOK, rec.Code)
+	}
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/systeminfo"))
+}
+
+func TestReadyHandler_RechecksAfterWindow(t *testing.T) {
+	server := newTestApiServer(t, "1.0.0")
+	defer server.Close()
+
+	handler := ReadyHandler(NewReadinessCheck(cdio.NewTestApiClient(server.URL()), time.Millisecond, time.Second))
+
+	checkReady(handler)
+	time.Sleep(5 * time.Millisecond)
+	checkReady(handler)
+	testutil.Equals(t, 2, server.RequestCount("/api/v1/systeminfo"))
+}
+
+func TestReadyHandler_ApiKeyRejected(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithFaults(testutil.Fault{
+		Path:   "/api/v1/systeminfo",
+		Status: http.StatusForbidden,
+		Count:  1,
+	}))
+	defer server.Close()
+
+	handler := ReadyHandler(NewReadinessCheck(cdio.NewTestApiClient(server.URL()), time.Minute, 50*time.Millisecond))
+
+	rec := checkReady(handler)
+	testutil.Equals(t, http.StatusServiceUnavailable, rec.Code)
+	testutil.Assert(t, strings.Contains(rec.Body.String(), "api key was rejected"), "unexpected body: %s", rec.Body.String())
+
+	// failures are cached for the timeout, afterwards the api is contacted again
+	rec = checkReady(handler)
+	testutil.Equals(t, http.StatusServiceUnavailable, rec.Code)
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/systeminfo"))
+	time.Sleep(60 * time.Millisecond)
+	rec = checkReady(handler)
+	testutil.Equals(t, http.StatusOK, rec.Code)
+}
+
+func TestReadyHandler_ConcurrentCallersShareFailure(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb,
+		testutil.WithResponseDelay(20*time.Millisecond),
+		testutil.WithFaults(testutil.Fault{
+			Path:   "/api/v1/systeminfo",
+			Status: http.StatusInternalServerError,
+			Count:  100,
+		}),
+	)
+	defer server.Close()
+
+	client := cdio.NewTestApiClient(server.URL())
+	client.Retry.MaxAttempts = 1
+	handler := ReadyHandler(NewReadinessCheck(client, time.Minute, time.Second))
+
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = checkReady(handler).Code
+		}()
+	}
+	wg.Wait()
+
+	for _, code := range codes {
+		testutil.Equals(t, http.StatusServiceUnavailable, code)
+	}
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/systeminfo"))
+}
+
+func TestReadyHandler_Timeout(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithResponseDelay(100*time.Millisecond))
+	defer server.Close()
+
+	client := cdio.NewTestApiClient(server.URL())
+	client.Retry.MaxAttempts = 1
+	handler := ReadyHandler(NewReadinessCheck(client, time.Minute, 10*time.Millisecond))
+
+	rec := checkReady(handler)
+	testutil.Equals(t, http.StatusServiceUnavailable, rec.Code)
+	testutil.Assert(t, strings.Contains(rec.Body.String(), "timeout"), "unexpected body: %s", rec.Body.String())
+}
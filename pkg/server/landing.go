@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/schaermu/changedetection.io-exporter/pkg/collectors"
+	"github.com/schaermu/changedetection.io-exporter/pkg/data"
+	"github.com/schaermu/changedetection.io-exporter/pkg/version"
+	log "github.com/sirupsen/logrus"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>changedetection.io exporter</title>
+  <style>
+    body { font-family: sans-serif; margin: 2em; }
+    table { border-collapse: collapse; }
+    td, th { text-align: left; padding: 0.2em 1em 0.2em 0; }
+    .failed { color: #c00; }
+  </style>
+</head>
+<body>
+  <h1>changedetection.io exporter</h1>
+  <p>Version {{.Version}} (revision: {{.Revision}})</p>
+
+  <h2>Endpoints</h2>
+  <ul>
+    {{range .Endpoints}}<li><a href="{{.Path}}">{{.Path}}</a> - {{.Description}}</li>
+    {{end}}
+  </ul>
+
+  <h2>Status</h2>
+  <table>
+    <tr><th>changedetection.io</th><td>{{.Url}}</td></tr>
+    {{if .Refreshed}}
+    <tr><th>Last refresh</th><td>{{.Timestamp}} ({{.Age}} ago, took {{.Duration}})</td></tr>
+    <tr><th>Instance</th><td>{{if .Up}}up{{else}}<span class="failed">down</span>{{end}}</td></tr>
+    {{range .Results}}<tr><th>Collector {{.Name}}</th><td>{{if .Err}}<span class="failed">failed: {{.Err}}</span>{{else}}ok{{end}}</td></tr>
+    {{end}}
+    {{else}}
+    <tr><th>Last refresh</th><td>no refresh has finished yet</td></tr>
+    {{end}}
+  </table>
+</body>
+</html>
+`))
+
+type landingEndpoint struct {
+	Path        string
+	Description string
+}
+
+type landingResult struct {
+	Name string
+	Err  error
+}
+
+type landingData struct {
+	Version   string
+	Revision  string
+	Url       string
+	Endpoints []landingEndpoint
+
+	Refreshed bool
+	Up        bool
+	Timestamp string
+	Age       time.Duration
+	Duration  time.Duration
+	Results   []landingResult
+}
+
+// LandingPageHandler serves an overview of the endpoints and the status of the current exporter on
+// the root path.
+func (r *Reloader) LandingPageHandler(telemetryPath string) http.Handler {
+	endpoints := []landingEndpoint{
+		{telemetryPath, "metrics of the configured instance"},
+		{"/probe", "metrics of the instance passed as target parameter"},
+		{"/-/healthy", "health check"},
+		{"/-/ready", "readiness check"},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+
+		exporter := r.Current()
+		data := landingData{
+			Version:   version.Version,
+			Revision:  version.Revision,
+			Url:       data.RedactUrl(exporter.Config.Changedetection.Url),
+			Endpoints: endpoints,
+		}
+
+		// do not trigger a refresh, the page shows the state of the last one
+		snapshot := exporter.Store.Latest()
+		if !snapshot.Timestamp.IsZero() {
+			data.Refreshed = true
+			data.Up = snapshot.Up
+			data.Timestamp = snapshot.Timestamp.Format(time.RFC3339)
+			data.Age = time.Since(snapshot.Timestamp).Round(time.Second)
+			data.Duration = snapshot.Duration.Round(time.Millisecond)
+			for _, name := range collectors.CollectorNames() {
+				data.Results = append(data.Results, landingResult{Name: name, Err: snapshot.Results[name].Err})
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := landingTemplate.Execute(w, data); err != nil {
+			log.Errorf("could not render landing page: %v", err)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/config"
+	"github.com/schaermu/changedetection.io-exporter/pkg/version"
+)
+
+func getLandingPage(handler http.Handler, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestLandingPageHandler(t *testing.T) {
+	server := newTestApiServer(t, "1.0.0")
+	defer server.Close()
+
+	cfg := newTestConfig(server.URL())
+	reloader, err := NewReloader(func() (*config.Config, error) { return cfg, nil }, prometheus.NewRegistry(), cdio.NewClientMetrics())
+	testutil.Ok(t, err)
+	defer reloader.Current().Stop()
+	<-reloader.Current().Store.Ready()
+
+	rec := getLandingPage(reloader.LandingPageHandler("/custom-metrics"), "/")
+	body := rec.Body.String()
+
+	testutil.Equals(t, http.StatusOK, rec.Code)
+	testutil.Equals(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	for _, expected := range []string{
+		`<a href="/custom-metrics">`,
+		`<a href="/probe">`,
+		`<a href="/-/ready">`,
+		server.URL(),
+		"Version " + version.Version,
+		"<th>Instance</th><td>up</td>",
+		"<th>Collector price</th><td>ok</td>",
+	} {
+		testutil.Assert(t, strings.Contains(body, expected), "expected %q in landing page:\n%s", expected, body)
+	}
+	testutil.Assert(t, !strings.Contains(body, "foo-bar-key"), "expected api key not to be shown")
+}
+
+func TestLandingPageHandler_BeforeFirstRefresh(t *testing.T) {
+	server := newTestApiServer(t, "1.0.0")
+	defer server.Close()
+
+	cfg := newTestConfig(server.URL())
+	cfg.Refresh.Interval = 0
+	reloader, err := NewReloader(func() (*config.Config, error) { return cfg, nil }, prometheus.NewRegistry(), cdio.NewClientMetrics())
+	testutil.Ok(t, err)
+
+	rec := getLandingPage(reloader.LandingPageHandler("/metrics"), "/")
+
+	testutil.Assert(t, strings.Contains(rec.Body.String(), "no refresh has finished yet"), "expected pending refresh")
+	testutil.Equals(t, 0, server.TotalRequestCount())
+}
+
+func TestLandingPageHandler_UnknownPath(t *testing.T) {
+	server := newTestApiServer(t, "1.0.0")
+	defer server.Close()
+
+	cfg := newTestConfig(server.URL())
+	cfg.Refresh.Interval = 0
+	reloader, err := NewReloader(func() (*config.Config, error) { return cfg, nil }, prometheus.NewRegistry(), cdio.NewClientMetrics())
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, http.StatusNotFound, getLandingPage(reloader.LandingPageHandler("/metrics"), "/does-not-exist").Code)
+}
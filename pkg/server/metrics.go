@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/schaermu/changedetection.io-exporter/pkg/collectors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+	// scrapeTimeoutOffset leaves some time to write the response before Prometheus gives up.
+	scrapeTimeoutOffset = 500 * time.Millisecond
+)
+
+// ScrapeContext derives a context from the request that is cancelled shortly before the scrape
+// timeout announced by Prometheus expires.
+func ScrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	header := r.Header.Get(scrapeTimeoutHeader)
+	if header == "" {
+		return context.WithCancel(r.Context())
+	}
+
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 0 {
+		log.Warnf("ignoring invalid %s header %q", scrapeTimeoutHeader, header)
+		return context.WithCancel(r.Context())
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// MetricsHandler serves the metrics of gatherer. Before doing so, it refreshes a stale snapshot
+// of store within the deadline of the scrape.
+func MetricsHandler(store *collectors.Store, gatherer prometheus.Gatherer) http.Handler {
+	handler := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		ErrorLog: log.StandardLogger(),
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := ScrapeContext(r)
+		defer cancel()
+
+		store.RefreshIfStale(ctx)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/collectors"
+)
+
+func TestScrapeContext_WithoutHeader(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	ctx, cancel := ScrapeContext(req)
+	defer cancel()
+
+	_, ok := ctx.Deadline()
+	testutil.Assert(t, !ok, "expected no deadline without scrape timeout header")
+}
+
+func TestScrapeContext_WithHeader(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set(scrapeTimeoutHeader, "10")
+	ctx, cancel := ScrapeContext(req)
+	defer cancel()
+
+	deadline, ok := ctx.Deadline()
+	testutil.Assert(t, ok, "expected deadline from scrape timeout header")
+	remaining := time.Until(deadline)
+	testutil.Assert(t, remaining <= 10*time.Second-scrapeTimeoutOffset, "expected deadline to include offset, got %s", remaining)
+	testutil.Assert(t, remaining > 9*time.Second, "expected deadline close to scrape timeout, got %s", remaining)
+}
+
+func TestScrapeContext_InvalidHeader(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set(scrapeTimeoutHeader, "soon")
+	ctx, cancel := ScrapeContext(req)
+	defer cancel()
+
+	_, ok := ctx.Deadline()
+	testutil.Assert(t, !ok, "expected invalid header to be ignored")
+}
+
+func TestMetricsHandler(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	store := collectors.NewStore(cdio.NewTestApiClient(server.URL()), collectors.WithMaxAge(time.Minute))
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collectors.NewWatchCollector(store))
+
+	rec := httptest.NewRecorder()
+	MetricsHandler(store, registry).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	testutil.Equals(t, http.StatusOK, rec.Code)
+	testutil.Assert(t, strings.Contains(rec.Body.String(), `changedetectionio_watch_check_count{source="www.item-1.org",title="Item 1"} 20`), "expected watch metrics in response")
+	testutil.Equals(t, 1, server.RequestCount("/api/v1/watch"))
+}
+
+func TestMetricsHandler_CancelsRefreshAtScrapeTimeout(t *testing.T) {
+	watchDb := testutil.NewWatchDb(10)
+	server := testutil.CreateTestApiServer(t, watchDb, testutil.WithResponseDelay(300*time.Millisecond))
+	defer server.Close()
+
+	store := collectors.NewStore(cdio.NewTestApiClient(server.URL()), collectors.WithMaxAge(time.Minute), collectors.WithConcurrency(1))
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collectors.NewWatchCollector(store))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set(scrapeTimeoutHeader, "1")
+	rec := httptest.NewRecorder()
+
+	start := time.Now()
+	MetricsHandler(store, registry).ServeHTTP(rec, req)
+	elapsed := time.Since(start)
+
+	testutil.Equals(t, http.StatusOK, rec.Code)
+	testutil.Assert(t, elapsed < time.Second, "expected scrape to finish before timeout, took %s", elapsed)
+}
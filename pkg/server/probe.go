@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/collectors"
+	"github.com/schaermu/changedetection.io-exporter/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// ProbeOptions configures the clients and stores created for every probe.
+type ProbeOptions struct {
+	// Modules maps module names to the API key sent to targets probed with that module, and the
+	// targets it may be sent to.
+	Modules      map[string]config.ModuleConfig
+	Retry        cdio.RetryPolicy
+	Metrics      *cdio.ClientMetrics
+	StoreOptions []collectors.StoreOption
+	// CollectorOptions configure the labels of the watch and price metrics.
+	CollectorOptions []collectors.CollectorOption
+}
+
+// ProbeHandler serves the metrics of the changedetection.io instance passed in the target query
+// parameter, using the API key of the module passed in the module query parameter. Targets not
+// listed by the module are rejected, so its API key is never sent anywhere else.
+func ProbeHandler(opts ProbeOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, err := parseTarget(r.URL.Query().Get("target"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		module := r.URL.Query().Get("module")
+		if module == "" {
+			module = config.DefaultModule
+		}
+		moduleConfig, ok := opts.Modules[module]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown module %q", module), http.StatusBadRequest)
+			return
+		}
+		if !moduleConfig.AllowsTarget(target) {
+			log.Warnf("rejected probe of %s using module %s, the target is not listed by the module", target, module)
+			http.Error(w, fmt.Sprintf("target %q is not allowed for module %q", target, module), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := ScrapeContext(r)
+		defer cancel()
+
+		client := cdio.NewApiClient(target, moduleConfig.ApiKey)
+		client.Retry = opts.Retry
+		client.Metrics = opts.Metrics
+		store := collectors.NewStore(client, opts.StoreOptions...)
+		store.Refresh(ctx)
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(
+			collectors.NewSystemCollector(store),
+			collectors.NewWatchCollector(store, opts.CollectorOptions...),
+			collectors.NewPriceCollector(store, opts.CollectorOptions...),
+			collectors.NewRefreshCollector(store),
+		)
+
+		log.Debugf("probed %s using module %s", target, module)
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+			ErrorLog: log.StandardLogger(),
+		}).ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// parseTarget validates the base url of a changedetection.io instance passed to the probe.
+func parseTarget(target string) (string, error) {
+	if target == "" {
+		return "", fmt.Errorf("target parameter is missing")
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid target %q: %v", target, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid target %q: must be an absolute http(s) url", target)
+	}
+	return strings.TrimSuffix(target, "/"), nil
+}
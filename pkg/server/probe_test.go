@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+	"github.com/schaermu/changedetection.io-exporter/pkg/cdio"
+	"github.com/schaermu/changedetection.io-exporter/pkg/config"
+)
+
+// newTestProbeOptions returns probe options whose modules may probe the given targets.
+func newTestProbeOptions(defaultTarget string, stagingTargets ...string) ProbeOptions {
+	return ProbeOptions{
+		Modules: map[string]config.ModuleConfig{
+			config.DefaultModule: {ApiKey: "foo-bar-key", Targets: []string{defaultTarget}},
+			"staging":            {ApiKey: "staging-key", Targets: stagingTargets},
+		},
+		Retry: cdio.RetryPolicy{MaxAttempts: 1},
+	}
+}
+
+func probe(handler http.Handler, params url.Values) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?"+params.Encode(), nil))
+	return rec
+}
+
+func TestProbeHandler(t *testing.T) {
+	_, watchDb := testutil.NewCollectorTestDb()
+	server := testutil.CreateTestApiServer(t, watchDb)
+	defer server.Close()
+
+	rec := probe(ProbeHandler(newTestProbeOptions(server.URL())), url.Values{"target": {server.URL()}})
+	body := rec.Body.String()
+
+	testutil.Equals(t, http.StatusOK, rec.Code)
+	testutil.Assert(t, strings.Contains(body, "changedetectionio_up 1"), "expected instance to be up")
+	testutil.Assert(t, strings.Contains(body, `changedetectionio_system_watch_count{version="1.0.0"} 2`), "expected system metrics")
+	testutil.Assert(t, strings.Contains(body, `changedetectionio_watch_check_count{source="www.item-1.org",title="Item 1"} 20`), "expected watch metrics")
+	testutil.Assert(t, strings.Contains(body, `changedetectionio_watch_price{currency="USD",source="www.item-2.org",title="Item 2"} 200`), "expected price metrics")
+	testutil.Assert(t, !strings.Contains(body, "go_goroutines"), "expected a fresh registry without default collectors")
+}
+
+func TestProbeHandler_UsesModuleApiKey(t *testing.T) {
+	var apiKeys []string
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		apiKeys = append(apiKeys, req.Header.Get("x-api-key"))
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer target.Close()
+
+	rec := probe(ProbeHandler(newTestProbeOptions("http://localhost:5000", target.URL+"/")), url.Values{"target": {target.URL}, "module": {"staging"}})
+
+	testutil.Equals(t, http.StatusOK, rec.Code)
+	testutil.Assert(t, strings.Contains(rec.Body.String(), "changedetectionio_up 0"), "expected instance to be down")
+	testutil.Assert(t, len(apiKeys) > 0, "expected probe to reach the target")
+	for _, key := range apiKeys {
+		testutil.Equals(t, "staging-key", key)
+	}
+}
+
+func TestProbeHandler_UnlistedTarget(t *testing.T) {
+	requests := 0
+	target := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests++
+	}))
+	defer target.Close()
+
+	handler := ProbeHandler(newTestProbeOptions("http://localhost:5000", "http://staging:5000"))
+	for _, module := range []string{config.DefaultModule, "staging"} {
+		rec := probe(handler, url.Values{"target": {target.URL}, "module": {module}})
+
+		testutil.Equals(t, http.StatusBadRequest, rec.Code)
+		testutil.Assert(t, strings.Contains(rec.Body.String(), "is not allowed for module"), "expected unlisted target error")
+	}
+	testutil.Equals(t, 0, requests)
+}
+
+func TestProbeHandler_UnknownModule(t *testing.T) {
+	rec := probe(ProbeHandler(newTestProbeOptions("http://localhost:5000")), url.Values{"target": {"http://localhost:5000"}, "module": {"prod"}})
+
+	testutil.Equals(t, http.StatusBadRequest, rec.Code)
+	testutil.Assert(t, strings.Contains(rec.Body.String(), `unknown module "prod"`), "expected unknown module error")
+}
+
+func TestProbeHandler_InvalidTarget(t *testing.T) {
+	handler := ProbeHandler(newTestProbeOptions("http://localhost:5000"))
+	for _, target := range []string{"", "localhost:5000", "ftp://localhost", "http://"} {
+		rec := probe(handler, url.Values{"target": {target}})
+		testutil.Equals(t, http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	target, err := parseTarget("https://changedetection.example.com/")
+	testutil.Ok(t, err)
+	testutil.Equals(t, "https://changedetection.example.com", target)
+}
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
+	log "github.com/sirupsen/logrus"
+)
+
+// Serve accepts connections on listener and serves them using server. If webConfigFile is set, TLS,
+// client certificate and basic authentication are enabled as configured in that file, which uses
+// the format of the prometheus exporter-toolkit. The file is re-read on every new connection.
+func Serve(listener net.Listener, server *http.Server, webConfigFile string) error {
+	return web.Serve(listener, server, webFlags(listener.Addr().String(), webConfigFile), logrusAdapter{})
+}
+
+// ServeGracefully serves like Serve until ctx is done. It then stops accepting new connections and
+// waits up to gracePeriod for requests in flight to finish before closing the remaining ones.
+func ServeGracefully(ctx context.Context, listener net.Listener, server *http.Server, webConfigFile string, gracePeriod time.Duration) error {
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(listener, server, webConfigFile)
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Infof("shutting down, waiting up to %s for requests in flight", gracePeriod)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+		return fmt.Errorf("requests in flight did not finish within %s: %w", gracePeriod, err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// ValidateWebConfig checks the web configuration file including the certificates it references.
+func ValidateWebConfig(webConfigFile string) error {
+	if err := web.Validate(webConfigFile); err != nil {
+		return fmt.Errorf("invalid web configuration: %w", err)
+	}
+	return nil
+}
+
+func webFlags(address string, webConfigFile string) *web.FlagConfig {
+	systemdSocket := false
+	return &web.FlagConfig{
+		WebListenAddresses: &[]string{address},
+		WebSystemdSocket:   &systemdSocket,
+		WebConfigFile:      &webConfigFile,
+	}
+}
+
+// logrusAdapter forwards the key/value pairs logged by the exporter-toolkit to logrus.
+type logrusAdapter struct{}
+
+func (logrusAdapter) Log(keyvals ...interface{}) error {
+	level := log.InfoLevel
+	msg := ""
+	fields := log.Fields{}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		switch key {
+		case "level":
+			if parsed, err := log.ParseLevel(fmt.Sprint(keyvals[i+1])); err == nil {
+				level = parsed
+			}
+		case "msg":
+			msg = fmt.Sprint(keyvals[i+1])
+		default:
+			fields[key] = keyvals[i+1]
+		}
+	}
+	log.WithFields(fields).Log(level, msg)
+	return nil
+}
@@ -0,0 +1,261 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testCertificates contains a CA and a server and client certificate signed by it, all written
+// as PEM files to a temporary directory.
+type testCertificates struct {
+	dir        string
+	pool       *x509.CertPool
+	clientCert tls.Certificate
+}
+
+func newTestCertificates(t *testing.T) *testCertificates {
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testutil.Ok(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	testutil.Ok(t, err)
+	caCert, err := x509.ParseCertificate(caDer)
+	testutil.Ok(t, err)
+	writePem(t, filepath.Join(dir, "ca.crt"), "CERTIFICATE", caDer)
+
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		testutil.Ok(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		testutil.Ok(t, err)
+		keyDer, err := x509.MarshalECPrivateKey(key)
+		testutil.Ok(t, err)
+		writePem(t, filepath.Join(dir, name+".crt"), "CERTIFICATE", der)
+		writePem(t, filepath.Join(dir, name+".key"), "EC PRIVATE KEY", keyDer)
+
+		cert, err := tls.LoadX509KeyPair(filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key"))
+		testutil.Ok(t, err)
+		return cert
+	}
+	issue("server", 2, x509.ExtKeyUsageServerAuth)
+	clientCert := issue("client", 3, x509.ExtKeyUsageClientAuth)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	return &testCertificates{dir: dir, pool: pool, clientCert: clientCert}
+}
+
+func writePem(t *testing.T, path string, blockType string, der []byte) {
+	testutil.Ok(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+}
+
+func writeWebConfig(t *testing.T, dir string, content string) string {
+	path := filepath.Join(dir, "web.yml")
+	testutil.Ok(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+// serveTest serves a handler answering "ok" using the given web configuration and returns its address.
+func serveTest(t *testing.T, webConfigFile string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})}
+	go Serve(listener, server, webConfigFile)
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+func TestServe_BasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	testutil.Ok(t, err)
+	webConfig := writeWebConfig(t, t.TempDir(), fmt.Sprintf("basic_auth_users:\n  prometheus: %s\n", hash))
+	testutil.Ok(t, ValidateWebConfig(webConfig))
+
+	url := "http://" + serveTest(t, webConfig) + "/metrics"
+
+	res, err := http.Get(url)
+	testutil.Ok(t, err)
+	res.Body.Close()
+	testutil.Equals(t, http.StatusUnauthorized, res.StatusCode)
+
+	for password, expected := range map[string]int{"wrong": http.StatusUnauthorized, "secret": http.StatusOK} {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.SetBasicAuth("prometheus", password)
+		res, err := http.DefaultClient.Do(req)
+		testutil.Ok(t, err)
+		res.Body.Close()
+		testutil.Equals(t, expected, res.StatusCode)
+	}
+}
+
+func TestServe_TLS(t *testing.T) {
+	certs := newTestCertificates(t)
+	webConfig := writeWebConfig(t, certs.dir, "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n")
+	testutil.Ok(t, ValidateWebConfig(webConfig))
+
+	address := serveTest(t, webConfig)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: certs.pool}}}
+
+	res, err := client.Get("https://" + address + "/metrics")
+	testutil.Ok(t, err)
+	res.Body.Close()
+	testutil.Equals(t, http.StatusOK, res.StatusCode)
+
+	// plain http requests are rejected by the tls server
+	res, err = http.Get("http://" + address + "/metrics")
+	testutil.Ok(t, err)
+	res.Body.Close()
+	testutil.Equals(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestServe_ClientCertificate(t *testing.T) {
+	certs := newTestCertificates(t)
+	webConfig := writeWebConfig(t, certs.dir, `tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: ca.crt
+`)
+	testutil.Ok(t, ValidateWebConfig(webConfig))
+
+	url := "https://" + serveTest(t, webConfig) + "/metrics"
+
+	withoutCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: certs.pool}}}
+	_, err := withoutCert.Get(url)
+	testutil.Assert(t, err != nil, "expected request without client certificate to fail")
+
+	withCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      certs.pool,
+		Certificates: []tls.Certificate{certs.clientCert},
+	}}}
+	res, err := withCert.Get(url)
+	testutil.Ok(t, err)
+	res.Body.Close()
+	testutil.Equals(t, http.StatusOK, res.StatusCode)
+}
+
+func TestValidateWebConfig(t *testing.T) {
+	testutil.Ok(t, ValidateWebConfig(""))
+
+	dir := t.TempDir()
+	missingCert := writeWebConfig(t, dir, "tls_server_config:\n  cert_file: missing.crt\n  key_file: missing.key\n")
+	testutil.Assert(t, ValidateWebConfig(missingCert) != nil, "expected error for missing certificate")
+
+	unknownField := writeWebConfig(t, dir, "unknown: true\n")
+	testutil.Assert(t, ValidateWebConfig(unknownField) != nil, "expected error for unknown field")
+}
+
+func TestServeGracefully_WaitsForRequestsInFlight(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		fmt.Fprint(w, "ok")
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- ServeGracefully(ctx, listener, server, "", time.Second) }()
+
+	url := "http://" + listener.Addr().String() + "/metrics"
+	responses := make(chan int, 1)
+	go func() {
+		res, err := http.Get(url)
+		if err != nil {
+			responses <- 0
+			return
+		}
+		res.Body.Close()
+		responses <- res.StatusCode
+	}()
+	<-started
+
+	cancel()
+	// new connections are refused while the request in flight is still running
+	testutil.Assert(t, waitForRefusal(url), "expected new connections to be refused during shutdown")
+
+	close(release)
+	testutil.Equals(t, http.StatusOK, <-responses)
+	testutil.Ok(t, <-served)
+}
+
+func TestServeGracefully_GracePeriodExceeded(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- ServeGracefully(ctx, listener, server, "", 10*time.Millisecond) }()
+
+	go http.Get("http://" + listener.Addr().String() + "/metrics")
+	<-started
+	cancel()
+
+	err = <-served
+	testutil.Assert(t, errors.Is(err, context.DeadlineExceeded), "expected grace period to be exceeded, got %v", err)
+}
+
+func waitForRefusal(url string) bool {
+	for i := 0; i < 100; i++ {
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		res, err := client.Get(url)
+		if err != nil {
+			return true
+		}
+		res.Body.Close()
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Build information, injected at build time using
+// -ldflags "-X github.com/schaermu/changedetection.io-exporter/pkg/version.Version=...".
+var (
+	Version   = "dev"
+	Revision  = "unknown"
+	BuildDate = "unknown"
+)
+
+// GoVersion is the version of the go toolchain the binary was built with.
+var GoVersion = runtime.Version()
+
+// Print returns the build information of the exporter as printed by --version.
+func Print(program string) string {
+	return fmt.Sprintf("%s, version %s (revision: %s)\n  build date: %s\n  go version: %s\n  platform:   %s/%s",
+		program, Version, Revision, BuildDate, GoVersion, runtime.GOOS, runtime.GOARCH)
+}
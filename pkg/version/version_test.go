@@ -0,0 +1,21 @@
+// SPDX-FileCopyrightText: 2024 Stefan Schärmeli <schaermu@pm.me>
+// SPDX-License-Identifier: MIT
+package version
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/schaermu/changedetection.io-exporter/internal/testutil"
+)
+
+func TestPrint(t *testing.T) {
+	Version, Revision, BuildDate = "1.2.3", "abc1234", "2024-05-01T12:00:00Z"
+	t.Cleanup(func() { Version, Revision, BuildDate = "dev", "unknown", "unknown" })
+
+	out := Print("changedetectionio_exporter")
+
+	testutil.Assert(t, strings.HasPrefix(out, "changedetectionio_exporter, version 1.2.3 (revision: abc1234)"), "unexpected version line: %s", out)
+	testutil.Assert(t, strings.Contains(out, "build date: 2024-05-01T12:00:00Z"), "expected build date in output: %s", out)
+	testutil.Assert(t, strings.Contains(out, "go version: "+GoVersion), "expected go version in output: %s", out)
+}